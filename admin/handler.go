@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/transcoder"
+)
+
+// RetranscodeRequest selects the videos to encode again. All must be set when no filter is given,
+// so that the whole catalog is not reprocessed by accident.
+type RetranscodeRequest struct {
+	All   bool      `json:"all"`
+	Owner string    `json:"owner"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+}
+
+// RetranscodeResponse lists the videos queued for re-transcoding, and those skipped because their
+// original was not retained
+type RetranscodeResponse struct {
+	Enqueued []string `json:"enqueued"`
+	Skipped  []string `json:"skipped"`
+}
+
+// RetranscodeHandler serves POST /admin/retranscode, queueing re-transcodes of the selected videos
+// from their retained originals
+func RetranscodeHandler(w http.ResponseWriter, r *http.Request) {
+	var req RetranscodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.All && req.Owner == "" && req.From.IsZero() && req.To.IsZero() {
+		http.Error(w, "Select videos by owner, from or to, or set all", http.StatusBadRequest)
+		return
+	}
+
+	var selected []string
+	err := db.Default.ForEachUpload(func(upload db.Upload) bool {
+		switch {
+		case upload.Kind != db.KindVideo || !upload.Deleted.IsZero() || upload.Status != db.StatusReady:
+		case req.Owner != "" && upload.Owner != req.Owner:
+		case !req.From.IsZero() && upload.Created.Before(req.From):
+		case !req.To.IsZero() && !upload.Created.Before(req.To):
+		default:
+			selected = append(selected, upload.ID)
+		}
+		return true
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to select videos to re-transcode", "error", err)
+		http.Error(w, "Failed to select videos", http.StatusInternalServerError)
+		return
+	}
+
+	// Looked up outside of ForEachUpload, which holds a read transaction
+	resp := RetranscodeResponse{Enqueued: []string{}, Skipped: []string{}}
+	for _, id := range selected {
+		if transcoder.HasOriginal(id) {
+			resp.Enqueued = append(resp.Enqueued, id)
+		} else {
+			resp.Skipped = append(resp.Skipped, id)
+		}
+	}
+
+	// The queue is bounded, feed it in the background rather than blocking the request
+	go func(ids []string) {
+		for _, id := range ids {
+			transcoder.EnqueueRetranscode(id)
+		}
+	}(resp.Enqueued)
+
+	logging.FromContext(r.Context()).Info("queued videos for re-transcoding", "enqueued", len(resp.Enqueued), "skipped", len(resp.Skipped))
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
+// writeJSON writes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+}
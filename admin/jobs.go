@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/transcoder"
+	"github.com/LinuxSploit/TusAce/webhook"
+)
+
+// JobsHandler serves GET /admin/jobs with the recorded jobs as JSON, optionally filtered by status and type
+func JobsHandler(w http.ResponseWriter, r *http.Request) {
+	status, jobType := r.URL.Query().Get("status"), r.URL.Query().Get("type")
+
+	jobs := []db.Job{}
+	err := db.Default.ForEachJob(func(job db.Job) bool {
+		if (status == "" || job.Status == status) && (jobType == "" || job.Type == jobType) {
+			jobs = append(jobs, job)
+		}
+		return true
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list jobs", "error", err)
+		http.Error(w, "Failed to list jobs", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// FailedJobsHandler serves GET /admin/jobs/failed with the dead-lettered jobs as JSON
+func FailedJobsHandler(w http.ResponseWriter, r *http.Request) {
+	letters, err := db.Default.DeadLetters()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list dead letters", "error", err)
+		http.Error(w, "Failed to list failed jobs", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, letters)
+}
+
+// RequeueHandler serves POST /admin/jobs/{id}/requeue, queueing a dead-lettered job again.
+// The job type defaults to transcode and can be chosen with type=retranscode.
+func RequeueHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	jobType := r.URL.Query().Get("type")
+	if jobType == "" {
+		jobType = db.JobTranscode
+	}
+
+	err := transcoder.RequeueDeadLetter(id, jobType)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "No failed job to requeue", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to requeue job", "upload_id", id, "job", jobType, "error", err)
+		http.Error(w, "Failed to requeue job", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// CancelHandler serves POST /admin/jobs/{id}/cancel, stopping the running or queued jobs of an upload.
+// A video whose first transcode is cancelled is marked failed, it can be requeued later.
+func CancelHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !transcoder.Cancel(id) {
+		http.Error(w, "No running or queued job", http.StatusNotFound)
+		return
+	}
+
+	if job, err := db.Default.GetJob(id, db.JobTranscode); err == nil && job.Status != db.JobSucceeded {
+		if err := db.Default.PutDeadLetter(db.DeadLetter{UploadID: id, Type: db.JobTranscode, Class: transcoder.ClassCancelled, Error: "cancelled by an admin", Attempts: job.Attempts, Failed: time.Now().UTC()}); err != nil {
+			logging.FromContext(r.Context()).Error("failed to record cancelled transcode", "upload_id", id, "error", err)
+		}
+		if err := db.Default.SetStatus(id, db.StatusFailed); err != nil {
+			logging.FromContext(r.Context()).Error("failed to record upload status", "upload_id", id, "error", err)
+		}
+		job.Status, job.Error = db.JobCancelled, "cancelled by an admin"
+		webhook.PublishUpload(webhook.EventTranscodeFailed, id, &job)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
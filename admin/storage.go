@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/media"
+	"github.com/LinuxSploit/TusAce/utils"
+)
+
+// DirUsage is the disk usage of one storage directory
+type DirUsage struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	FreeBytes uint64 `json:"freeBytes"`
+}
+
+// storageDirs are the directories the service writes to, by name
+var storageDirs = []struct{ name, path string }{
+	{"videos", config.VideosDir},
+	{"images", config.ImagesDir},
+	{"hls", config.HLSDir},
+	{"thumbnail", config.ThumbnailDir},
+	{"staging", config.StagingDir},
+	{"archive", config.ArchiveDir},
+}
+
+// StorageHandler serves GET /admin/storage with the size of every storage directory and the free
+// space of the file system holding it
+func StorageHandler(w http.ResponseWriter, r *http.Request) {
+	usage := make([]DirUsage, 0, len(storageDirs))
+	for _, dir := range storageDirs {
+		free, err := utils.FreeSpace(dir.path)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("failed to read free space", "path", dir.path, "error", err)
+		}
+		usage = append(usage, DirUsage{Name: dir.name, Path: dir.path, Bytes: utils.DirSize(dir.path), FreeBytes: free})
+	}
+
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// CleanupHandler serves POST /admin/cleanup, running the janitor right away and returning its report.
+// With dryRun=true nothing is removed, the report tells what would be.
+func CleanupHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, media.Cleanup(r.URL.Query().Get("dryRun") == "true"))
+}
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/media"
+)
+
+// Page sizes of the upload listing
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// UploadsResponse is one page of the upload listing
+type UploadsResponse struct {
+	Items      []db.Upload `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// UploadDetail is the record of an upload with its jobs, derivatives and the files it occupies
+type UploadDetail struct {
+	Upload      db.Upload       `json:"upload"`
+	Jobs        []db.Job        `json:"jobs"`
+	Derivatives []db.Derivative `json:"derivatives"`
+	Files       []File          `json:"files"`
+}
+
+// File is one file of an upload on disk
+type File struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// UploadsHandler serves GET /admin/uploads, paging through the uploads of every owner.
+// Supported filters: owner, kind, status, deleted=true, cursor and limit.
+func UploadsHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := db.Query{
+		Owner:   params.Get("owner"),
+		Kind:    params.Get("kind"),
+		Status:  params.Get("status"),
+		Deleted: params.Get("deleted") == "true",
+		Cursor:  params.Get("cursor"),
+		Limit:   defaultListLimit,
+	}
+	if limit := params.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = min(parsed, maxListLimit)
+	}
+
+	uploads, nextCursor, err := db.Default.ListUploads(query)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list uploads", "error", err)
+		http.Error(w, "Failed to list uploads", http.StatusInternalServerError)
+		return
+	}
+	if uploads == nil {
+		uploads = []db.Upload{}
+	}
+
+	writeJSON(w, http.StatusOK, UploadsResponse{Items: uploads, NextCursor: nextCursor})
+}
+
+// UploadHandler serves GET /admin/uploads/{id} with the UploadDetail of an upload. Only the paths the
+// upload is recorded at are inspected, so the files of other uploads or the system are never listed.
+func UploadHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	upload, err := db.Default.GetUpload(id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to load upload", "upload_id", id, "error", err)
+		http.Error(w, "Failed to load upload", http.StatusInternalServerError)
+		return
+	}
+
+	detail := UploadDetail{Upload: upload, Jobs: []db.Job{}, Derivatives: []db.Derivative{}, Files: []File{}}
+	if jobs, err := db.Default.Jobs(id); err == nil && jobs != nil {
+		detail.Jobs = jobs
+	}
+	if derivatives, err := db.Default.Derivatives(id); err == nil && derivatives != nil {
+		detail.Derivatives = derivatives
+	}
+
+	// Thumbnails are both recorded as derivatives and matched by name, list them once
+	seen := map[string]bool{}
+	for _, path := range media.Paths(upload, detail.Derivatives) {
+		filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || seen[path] {
+				return nil
+			}
+			seen[path] = true
+			if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+				detail.Files = append(detail.Files, File{Path: path, Size: info.Size(), Modified: info.ModTime().UTC()})
+			}
+			return nil
+		})
+	}
+
+	writeJSON(w, http.StatusOK, detail)
+}
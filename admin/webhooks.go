@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/logging"
+)
+
+// DeliveriesHandler serves GET /admin/webhooks/deliveries with the webhook delivery log, newest first.
+// Supported filters: status (pending, delivered or failed) and limit.
+func DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultListLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxListLimit)
+	}
+
+	deliveries, err := db.Default.Deliveries(r.URL.Query().Get("status"), limit)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list webhook deliveries", "error", err)
+		http.Error(w, "Failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
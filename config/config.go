@@ -0,0 +1,276 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage layout of the mounted volume, every directory path ends with a slash
+var (
+	VideosDir    = "/storage/tus/videos/"
+	ImagesDir    = "/storage/tus/images/"
+	HLSDir       = "/storage/tus/hls/"
+	ThumbnailDir = "/storage/tus/thumbnail/"
+	// StagingDir receives HLS output while it is encoded, it must be on the same volume as HLSDir
+	StagingDir = "/storage/tus/staging/"
+)
+
+// StorageBackend is where uploads are stored and the HLS output and thumbnails are served from:
+// "local" uses the directories above, "s3" stores uploads with tusd's s3store and publishes the
+// outputs to S3Bucket. The transcoder and image processing work in the local directories either way.
+var StorageBackend = envChoice("STORAGE_BACKEND", "local", "local", "s3")
+
+// Bucket of the s3 storage backend, its keys start with S3Prefix. S3Endpoint points it at an
+// S3-compatible service such as MinIO, which usually also needs S3PathStyle. Credentials are read
+// from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or the shared AWS configuration.
+var (
+	S3Bucket    = envString("S3_BUCKET", "")
+	S3Prefix    = envString("S3_PREFIX", "")
+	S3Region    = envString("S3_REGION", "us-east-1")
+	S3Endpoint  = envString("S3_ENDPOINT", "")
+	S3PathStyle = envBool("S3_PATH_STYLE", false)
+)
+
+// Cache lifetimes of the published media: HLS segments never change once published, playlists are
+// swapped by re-transcodes and thumbnails are generated again along with them
+var (
+	SegmentMaxAge   = envDuration("SEGMENT_MAX_AGE", 365*24*time.Hour)
+	PlaylistMaxAge  = envDuration("PLAYLIST_MAX_AGE", 10*time.Second)
+	ThumbnailMaxAge = envDuration("THUMBNAIL_MAX_AGE", 24*time.Hour)
+)
+
+// SupersededHLSRetention is how long the HLS output replaced by a re-transcode is kept once the
+// master playlist stopped pointing at it, on top of PlaylistMaxAge, so that players and caches still
+// holding the previous playlists can finish playing. The janitor removes it afterwards.
+var SupersededHLSRetention = envDuration("SUPERSEDED_HLS_RETENTION", 24*time.Hour)
+
+// DatabasePath is the location of the embedded database recording uploads, jobs and derivatives
+var DatabasePath = envString("DATABASE_PATH", "/storage/tus/media.db")
+
+// DeleteGracePeriod is how long deleted media can be restored before its files are purged, 0 purges immediately
+var DeleteGracePeriod = envDuration("DELETE_GRACE_PERIOD", 7*24*time.Hour)
+
+// IncompleteUploadTTL is how long an upload may stay unfinished before the janitor removes it
+var IncompleteUploadTTL = envDuration("INCOMPLETE_UPLOAD_TTL", 24*time.Hour)
+
+// JanitorInterval is the time between two cleanup runs of the janitor
+var JanitorInterval = envDuration("JANITOR_INTERVAL", time.Hour)
+
+// OriginalRetention decides what happens to an original video once it is transcoded:
+// "delete" removes it, "keep" leaves it in VideosDir and "archive" moves it to ArchiveDir.
+// With the s3 storage backend the transcoded original is removed from the bucket in every case.
+var OriginalRetention = envChoice("ORIGINAL_RETENTION", "delete", "delete", "keep", "archive")
+
+// ArchiveDir holds one directory per archived original, containing the data. Its tusd .info stays in
+// VideosDir where the import of uploads reads it.
+var ArchiveDir = envString("ARCHIVE_DIR", "/storage/tus/archive/")
+
+// ArchiveCompression is applied to archived originals, "none" or "zstd"
+var ArchiveCompression = envChoice("ARCHIVE_COMPRESSION", "none", "none", "zstd")
+
+// TranscodeMaxAttempts is how often a transcode failing with a transient error is tried before it is dead-lettered
+var TranscodeMaxAttempts = envInt("TRANSCODE_MAX_ATTEMPTS", 3)
+
+// TranscodeRetryBackoff is the delay before the first retry of a failed transcode, doubled on every further
+// retry up to TranscodeRetryMaxBackoff
+var (
+	TranscodeRetryBackoff    = envDuration("TRANSCODE_RETRY_BACKOFF", 30*time.Second)
+	TranscodeRetryMaxBackoff = envDuration("TRANSCODE_RETRY_MAX_BACKOFF", time.Hour)
+)
+
+// Transcode jobs time out after TranscodeTimeoutFactor times the duration of the source video,
+// but never before TranscodeTimeoutMin or after TranscodeTimeoutMax. Jobs whose duration cannot
+// be probed get TranscodeTimeoutMax.
+var (
+	TranscodeTimeoutMin    = envDuration("TRANSCODE_TIMEOUT_MIN", 10*time.Minute)
+	TranscodeTimeoutMax    = envDuration("TRANSCODE_TIMEOUT_MAX", 6*time.Hour)
+	TranscodeTimeoutFactor = envFloat("TRANSCODE_TIMEOUT_FACTOR", 4)
+)
+
+// TranscodeKillGrace is how long ffmpeg may take to exit after SIGTERM before it is killed
+var TranscodeKillGrace = envDuration("TRANSCODE_KILL_GRACE", 10*time.Second)
+
+// ShutdownTimeout is how long requests and the running transcode get to finish after SIGINT or SIGTERM
+var ShutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+
+// ReadyMinFreeBytes is the free space every storage directory needs for /readyz to report ready
+var ReadyMinFreeBytes = envInt64("READY_MIN_FREE_BYTES", 1<<30)
+
+// ReadyQueueThreshold is the fill ratio of the transcode queue from which /readyz reports it saturated
+var ReadyQueueThreshold = envFloat("READY_QUEUE_THRESHOLD", 0.9)
+
+// WebhookURLs receive a signed POST for every webhook event, comma separated. Webhooks are off while it is empty.
+var WebhookURLs = envList("WEBHOOK_URLS")
+
+// WebhookSecret is the key of the HMAC-SHA256 signature sent with every webhook, required when WebhookURLs is set
+var WebhookSecret = envString("WEBHOOK_SECRET", "")
+
+// WebhookEvents limits the events sent to the webhook URLs, comma separated, all events are sent while it is empty
+var WebhookEvents = envList("WEBHOOK_EVENTS")
+
+// A webhook delivery is attempted WebhookMaxAttempts times, waiting WebhookRetryBackoff before the first
+// retry and twice as long before every further one, up to WebhookRetryMaxBackoff. Each attempt times out
+// after WebhookTimeout.
+var (
+	WebhookMaxAttempts     = envInt("WEBHOOK_MAX_ATTEMPTS", 6)
+	WebhookRetryBackoff    = envDuration("WEBHOOK_RETRY_BACKOFF", 10*time.Second)
+	WebhookRetryMaxBackoff = envDuration("WEBHOOK_RETRY_MAX_BACKOFF", time.Hour)
+	WebhookTimeout         = envDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+)
+
+// WebhookLogRetention is how long finished webhook deliveries stay in the delivery log
+var WebhookLogRetention = envDuration("WEBHOOK_LOG_RETENTION", 7*24*time.Hour)
+
+// LogLevel is the minimum level of the JSON logs: debug, info, warn or error
+var LogLevel = envString("LOG_LEVEL", "info")
+
+// AdminToken is the bearer token of the admin API, the API is disabled while it is empty
+var AdminToken = envString("ADMIN_TOKEN", "")
+
+// PublicBaseURL is the externally reachable origin of the server, used for tus upload locations and media URLs
+var PublicBaseURL = envString("PUBLIC_BASE_URL", "https://tus-server-production.up.railway.app")
+
+// Origins allowed to call each group of routes cross-origin, comma separated: the tus upload
+// handlers, the HLS and thumbnail file servers and the media API. An entry is an origin such as
+// https://app.example.com, https://*.example.com for its subdomains or * for any origin. Every group
+// falls back to CORSOrigins.
+//
+// The defaults are open: unless CORS_ORIGINS is set, every group allows any origin. Deployments
+// serving a known set of front-ends should list them, and must do so to allow credentials.
+var (
+	CORSOrigins         = envListOr("CORS_ORIGINS", []string{"*"})
+	CORSUploadOrigins   = envListOr("CORS_UPLOAD_ORIGINS", CORSOrigins)
+	CORSPlaybackOrigins = envListOr("CORS_PLAYBACK_ORIGINS", CORSOrigins)
+	CORSAPIOrigins      = envListOr("CORS_API_ORIGINS", CORSOrigins)
+)
+
+// CORSAllowCredentials lets browsers send cookies with cross-origin uploads and API calls. The
+// service refuses to start when it is enabled while the upload or API routes allow any origin.
+var CORSAllowCredentials = envBool("CORS_ALLOW_CREDENTIALS", false)
+
+// Limits applied to uploaded images before they are decoded, overridable through the environment
+var (
+	// MaxImagePixels is the largest width*height accepted for an image upload
+	MaxImagePixels = envInt64("MAX_IMAGE_PIXELS", 50_000_000)
+	// MaxImageWidth and MaxImageHeight bound each side of an image upload in pixels
+	MaxImageWidth  = envInt("MAX_IMAGE_WIDTH", 12_000)
+	MaxImageHeight = envInt("MAX_IMAGE_HEIGHT", 12_000)
+	// ImageDecodeMemory is the total number of bytes that concurrent image decodes may hold at once
+	ImageDecodeMemory = envInt64("IMAGE_DECODE_MEMORY", 512*1024*1024)
+)
+
+// ImageVariants lists the WebP derivatives generated for image uploads and video thumbnails,
+// see utils.ParseImageVariants for the format
+var ImageVariants = envString("IMAGE_VARIANTS", "500w,500x500:fill,400x500:smart")
+
+// envString returns the value of the environment variable key, or fallback if it is unset
+func envString(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envList returns the comma separated values of the environment variable key, without empty values
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(envString(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// envListOr returns the comma separated values of the environment variable key, or fallback if it has none
+func envListOr(key string, fallback []string) []string {
+	if values := envList(key); len(values) > 0 {
+		return values
+	}
+	return fallback
+}
+
+// envChoice returns the environment variable key if it is one of choices, or fallback if it is unset or invalid
+func envChoice(key, fallback string, choices ...string) string {
+	value := envString(key, fallback)
+	for _, choice := range choices {
+		if value == choice {
+			return value
+		}
+	}
+
+	slog.Warn("invalid configuration value, using default", "key", key, "value", value, "default", fallback)
+	return fallback
+}
+
+// envInt returns the environment variable key parsed as an int, or fallback if it is unset or invalid
+func envInt(key string, fallback int) int {
+	return int(envInt64(key, int64(fallback)))
+}
+
+// envInt64 returns the environment variable key parsed as an int64, or fallback if it is unset or invalid
+func envInt64(key string, fallback int64) int64 {
+	value := envString(key, "")
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		slog.Warn("invalid configuration value, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+
+	return parsed
+}
+
+// envBool returns the environment variable key parsed as a bool, or fallback if it is unset or invalid
+func envBool(key string, fallback bool) bool {
+	value := envString(key, "")
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("invalid configuration value, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+
+	return parsed
+}
+
+// envFloat returns the environment variable key parsed as a float64, or fallback if it is unset or invalid
+func envFloat(key string, fallback float64) float64 {
+	value := envString(key, "")
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("invalid configuration value, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+
+	return parsed
+}
+
+// envDuration returns the environment variable key parsed as a duration such as "90m", or fallback if it is unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := envString(key, "")
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("invalid configuration value, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+
+	return parsed
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Buckets of the database
+var (
+	bucketMeta          = []byte("meta")
+	bucketUploads       = []byte("uploads")
+	bucketUploadsByTime = []byte("uploads_by_created")
+	bucketUploadsByUser = []byte("uploads_by_owner")
+	bucketJobs          = []byte("jobs")
+	bucketDerivatives   = []byte("derivatives")
+	bucketDeadLetters   = []byte("dead_letters")
+	bucketDeliveries    = []byte("webhook_deliveries")
+	bucketPurged        = []byte("purged_uploads")
+)
+
+// keySchemaVersion holds the number of migrations applied to the database
+var keySchemaVersion = []byte("schema_version")
+
+// DB is the embedded database recording every upload, its owner and metadata, its processing jobs
+// and the derivative files produced from it. It is the source of truth for the media API and cleanup.
+type DB struct {
+	bolt *bbolt.DB
+}
+
+// Default is the database shared by the tus handlers, the transcoder and the APIs, opened by main
+var Default *DB
+
+// migrations are applied in order, each one exactly once. Never edit or reorder a released
+// migration, append a new one instead.
+var migrations = []func(tx *bbolt.Tx) error{
+	// 1: initial schema
+	func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{bucketUploads, bucketUploadsByTime, bucketUploadsByUser, bucketJobs, bucketDerivatives} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: jobs that failed for good
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketDeadLetters)
+		return err
+	},
+	// 3: webhook delivery log
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketDeliveries)
+		return err
+	},
+	// 4: ids of the purged uploads
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketPurged)
+		return err
+	},
+}
+
+// Open opens or creates the database at path and migrates it to the latest schema
+func Open(path string) (*DB, error) {
+	bolt, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	db := &DB{bolt: bolt}
+	if err := db.migrate(); err != nil {
+		bolt.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Close closes the database file
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
+
+// migrate applies the migrations that are newer than the stored schema version. They run in a single
+// transaction, so a failing migration leaves the database as it was.
+func (db *DB) migrate() error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if raw := meta.Get(keySchemaVersion); raw != nil {
+			version = int(binary.BigEndian.Uint64(raw))
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("database schema version %d is newer than this build supports (%d)", version, len(migrations))
+		}
+
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d failed: %w", version+1, err)
+			}
+			slog.Info("applied database migration", "version", version+1)
+		}
+
+		return meta.Put(keySchemaVersion, binary.BigEndian.AppendUint64(nil, uint64(version)))
+	})
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// newTestDB opens a database in a temporary directory, closed when the test ends
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "media.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// schemaVersion reads the stored schema version of the database at path
+func schemaVersion(t *testing.T, path string) int {
+	t.Helper()
+	bolt, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	version := -1
+	bolt.View(func(tx *bbolt.Tx) error {
+		if raw := tx.Bucket(bucketMeta).Get(keySchemaVersion); raw != nil {
+			version = int(binary.BigEndian.Uint64(raw))
+		}
+		return nil
+	})
+	return version
+}
+
+// writeSchema creates a database at path holding the buckets of the first applied migrations, an
+// upload and the given schema version, the way an older build left it
+func writeSchema(t *testing.T, path string, applied, version int) {
+	t.Helper()
+	bolt, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	err = bolt.Update(func(tx *bbolt.Tx) error {
+		for _, migration := range migrations[:applied] {
+			if err := migration(tx); err != nil {
+				return err
+			}
+		}
+		if err := putUpload(tx, Upload{ID: "kept", Owner: "owner", Created: time.Now()}); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		return meta.Put(keySchemaVersion, binary.BigEndian.AppendUint64(nil, uint64(version)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	latest := len(migrations)
+	tests := []struct {
+		name    string
+		applied int
+		// version is the stored schema version, -1 for a new database file
+		version int
+		wantErr bool
+	}{
+		{name: "new database", version: -1},
+		{name: "initial schema", applied: 1, version: 1},
+		{name: "one migration behind", applied: latest - 1, version: latest - 1},
+		{name: "up to date", applied: latest, version: latest},
+		{name: "newer than this build", applied: latest, version: latest + 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "media.db")
+			if test.version >= 0 {
+				writeSchema(t, path, test.applied, test.version)
+			}
+
+			db, err := Open(path)
+			if test.wantErr {
+				if err == nil {
+					db.Close()
+					t.Fatal("Open succeeded")
+				}
+				if got := schemaVersion(t, path); got != test.version {
+					t.Errorf("schema version = %d after the refused migration, want %d", got, test.version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every bucket of the latest schema exists, the data of the older schema is kept
+			err = db.bolt.View(func(tx *bbolt.Tx) error {
+				for _, bucket := range [][]byte{bucketUploads, bucketUploadsByTime, bucketUploadsByUser, bucketJobs, bucketDerivatives, bucketDeadLetters, bucketDeliveries, bucketPurged} {
+					if tx.Bucket(bucket) == nil {
+						t.Errorf("bucket %s is missing", bucket)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.version >= 0 && !db.HasUpload("kept") {
+				t.Error("the upload recorded before the migration is gone")
+			}
+			db.Close()
+
+			if got := schemaVersion(t, path); got != latest {
+				t.Errorf("schema version = %d, want %d", got, latest)
+			}
+
+			// Opening a migrated database again applies nothing
+			db, err = Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+			if got := schemaVersion(t, path); got != latest {
+				t.Errorf("schema version = %d after reopening, want %d", got, latest)
+			}
+		})
+	}
+}
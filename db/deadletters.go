@@ -0,0 +1,87 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// DeadLetter is a job that failed for good, either because its error cannot be fixed by retrying
+// or because it ran out of attempts. It stays here until it is requeued or its upload is purged.
+type DeadLetter struct {
+	UploadID string `json:"uploadId"`
+	Type     string `json:"type"`
+	// Class tells whether the last error was transient or caused by the input
+	Class    string    `json:"class"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Failed   time.Time `json:"failed"`
+	// StderrTail is the end of the ffmpeg script output of the last attempt
+	StderrTail string `json:"stderrTail,omitempty"`
+}
+
+// PutDeadLetter records a failed job, replacing a previous dead letter of the same job
+func (db *DB) PutDeadLetter(letter DeadLetter) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		raw, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketDeadLetters).Put(deadLetterKey(letter.UploadID, letter.Type), raw)
+	})
+}
+
+// GetDeadLetter returns the dead letter of a job
+func (db *DB) GetDeadLetter(uploadID, jobType string) (DeadLetter, error) {
+	var letter DeadLetter
+	err := db.bolt.View(func(tx *bbolt.Tx) error {
+		raw := tx.Bucket(bucketDeadLetters).Get(deadLetterKey(uploadID, jobType))
+		if raw == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(raw, &letter)
+	})
+	return letter, err
+}
+
+// DeleteDeadLetter removes the dead letter of a job
+func (db *DB) DeleteDeadLetter(uploadID, jobType string) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketDeadLetters).Delete(deadLetterKey(uploadID, jobType))
+	})
+}
+
+// DeadLetters returns every failed job, ordered by upload id
+func (db *DB) DeadLetters() ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	err := db.bolt.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketDeadLetters).ForEach(func(_, raw []byte) error {
+			var letter DeadLetter
+			if err := json.Unmarshal(raw, &letter); err != nil {
+				return err
+			}
+			letters = append(letters, letter)
+			return nil
+		})
+	})
+	return letters, err
+}
+
+// deleteDeadLetters removes the dead letters of every job of an upload inside tx
+func deleteDeadLetters(tx *bbolt.Tx, uploadID string) error {
+	prefix := deadLetterKey(uploadID, "")
+	c := tx.Bucket(bucketDeadLetters).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deadLetterKey is the upload id and job type separated by a slash
+func deadLetterKey(uploadID, jobType string) []byte {
+	return []byte(uploadID + "/" + jobType)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is one webhook event sent to one URL, with the outcome of its latest attempt
+type Delivery struct {
+	ID       string `json:"id"`
+	Event    string `json:"event"`
+	UploadID string `json:"uploadId"`
+	URL      string `json:"url"`
+	// Payload is the signed body, sent unchanged on every attempt
+	Payload  json.RawMessage `json:"payload"`
+	Status   string          `json:"status"`
+	Attempts int             `json:"attempts"`
+	// ResponseStatus is the HTTP status of the latest attempt, 0 if the receiver did not answer
+	ResponseStatus int       `json:"responseStatus,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+	// NextAttempt is when a pending delivery is retried
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
+}
+
+// PutDelivery records the latest state of a delivery
+func (db *DB) PutDelivery(delivery Delivery) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		delivery.Updated = time.Now().UTC()
+		raw, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketDeliveries).Put(deliveryKey(delivery), raw)
+	})
+}
+
+// Deliveries returns the deliveries with the given status, or all of them if status is empty,
+// newest first and at most limit of them unless limit is 0
+func (db *DB) Deliveries(status string, limit int) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := db.bolt.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketDeliveries).Cursor()
+		for k, raw := c.First(); k != nil; k, raw = c.Next() {
+			var delivery Delivery
+			if err := json.Unmarshal(raw, &delivery); err != nil {
+				return err
+			}
+			if status != "" && delivery.Status != status {
+				continue
+			}
+			deliveries = append(deliveries, delivery)
+			if len(deliveries) == limit {
+				return nil
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// PruneDeliveries removes the finished deliveries created before cutoff and returns how many were removed.
+// Pending deliveries are kept, they are still being retried.
+func (db *DB) PruneDeliveries(cutoff time.Time) (int, error) {
+	pruned := 0
+	err := db.bolt.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketDeliveries)
+
+		// Keys are ordered newest first, so everything from the cutoff key on is older
+		var old [][]byte
+		c := bucket.Cursor()
+		start := binary.BigEndian.AppendUint64(nil, math.MaxUint64-uint64(cutoff.UnixNano()))
+		for k, raw := c.Seek(start); k != nil; k, raw = c.Next() {
+			var delivery Delivery
+			if err := json.Unmarshal(raw, &delivery); err != nil {
+				return err
+			}
+			if delivery.Status != DeliveryPending {
+				old = append(old, k)
+			}
+		}
+
+		// Deleting while iterating makes the cursor skip keys
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(old)
+		return nil
+	})
+	return pruned, err
+}
+
+// deliveryKey orders deliveries newest first like timeKey: the inverted creation time followed by the id
+func deliveryKey(delivery Delivery) []byte {
+	key := binary.BigEndian.AppendUint64(nil, math.MaxUint64-uint64(delivery.Created.UnixNano()))
+	return append(key, delivery.ID...)
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"encoding/json"
+
+	"go.etcd.io/bbolt"
+)
+
+// Kinds of derivative files
+const (
+	DerivativeHLS       = "hls"
+	DerivativeThumbnail = "thumbnail"
+	DerivativeOriginal  = "original"
+)
+
+// Derivative is a file produced from an upload, such as the HLS output of a video or a WebP variant
+type Derivative struct {
+	Kind string `json:"kind"`
+	// Name identifies the derivative within its kind, e.g. the image variant name
+	Name string `json:"name"`
+	// Path is the absolute path of the file, or of the directory for HLS output and archived originals
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// PutDerivatives replaces the derivatives of the given kind recorded for an upload
+func (db *DB) PutDerivatives(uploadID, kind string, derivatives []Derivative) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		existing, err := getDerivatives(tx, uploadID)
+		if err != nil {
+			return err
+		}
+
+		kept := make([]Derivative, 0, len(existing)+len(derivatives))
+		for _, derivative := range existing {
+			if derivative.Kind != kind {
+				kept = append(kept, derivative)
+			}
+		}
+		kept = append(kept, derivatives...)
+
+		raw, err := json.Marshal(kept)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketDerivatives).Put([]byte(uploadID), raw)
+	})
+}
+
+// Derivatives returns every derivative recorded for an upload
+func (db *DB) Derivatives(uploadID string) ([]Derivative, error) {
+	var derivatives []Derivative
+	err := db.bolt.View(func(tx *bbolt.Tx) error {
+		var err error
+		derivatives, err = getDerivatives(tx, uploadID)
+		return err
+	})
+	return derivatives, err
+}
+
+// getDerivatives reads the derivatives of an upload inside tx
+func getDerivatives(tx *bbolt.Tx, uploadID string) ([]Derivative, error) {
+	var derivatives []Derivative
+	raw := tx.Bucket(bucketDerivatives).Get([]byte(uploadID))
+	if raw == nil {
+		return derivatives, nil
+	}
+	err := json.Unmarshal(raw, &derivatives)
+	return derivatives, err
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Job types
+const (
+	JobTranscode   = "transcode"
+	JobRetranscode = "retranscode"
+)
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is one processing run for an upload, such as a transcode
+type Job struct {
+	UploadID string    `json:"uploadId"`
+	Type     string    `json:"type"`
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+	// NextAttempt is when a job queued for a retry runs again
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
+}
+
+// PutJob records the latest state of a job, replacing the previous job of the same type for the upload
+func (db *DB) PutJob(job Job) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		jobs, err := getJobs(tx, job.UploadID)
+		if err != nil {
+			return err
+		}
+
+		replaced := false
+		for i := range jobs {
+			if jobs[i].Type == job.Type {
+				jobs[i], replaced = job, true
+			}
+		}
+		if !replaced {
+			jobs = append(jobs, job)
+		}
+
+		raw, err := json.Marshal(jobs)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketJobs).Put([]byte(job.UploadID), raw)
+	})
+}
+
+// GetJob returns the job of the given type for an upload
+func (db *DB) GetJob(uploadID, jobType string) (Job, error) {
+	jobs, err := db.Jobs(uploadID)
+	if err != nil {
+		return Job{}, err
+	}
+	for _, job := range jobs {
+		if job.Type == jobType {
+			return job, nil
+		}
+	}
+	return Job{}, ErrNotFound
+}
+
+// Jobs returns every job recorded for an upload
+func (db *DB) Jobs(uploadID string) ([]Job, error) {
+	var jobs []Job
+	err := db.bolt.View(func(tx *bbolt.Tx) error {
+		var err error
+		jobs, err = getJobs(tx, uploadID)
+		return err
+	})
+	return jobs, err
+}
+
+// ForEachJob calls fn for every recorded job until fn returns false
+func (db *DB) ForEachJob(fn func(job Job) bool) error {
+	return db.bolt.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketJobs).Cursor()
+		for k, raw := c.First(); k != nil; k, raw = c.Next() {
+			var jobs []Job
+			if err := json.Unmarshal(raw, &jobs); err != nil {
+				return err
+			}
+			for _, job := range jobs {
+				if !fn(job) {
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// getJobs reads the jobs of an upload inside tx
+func getJobs(tx *bbolt.Tx, uploadID string) ([]Job, error) {
+	var jobs []Job
+	raw := tx.Bucket(bucketJobs).Get([]byte(uploadID))
+	if raw == nil {
+		return jobs, nil
+	}
+	err := json.Unmarshal(raw, &jobs)
+	return jobs, err
+}
@@ -0,0 +1,347 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Kinds of media, matching the tus endpoint the upload was made through
+const (
+	KindVideo = "video"
+	KindImage = "image"
+)
+
+// Processing states of an upload
+const (
+	StatusUploading  = "uploading"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+)
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidCursor is returned by ListUploads for a cursor it did not hand out for the same query
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Upload is the record of one tus upload
+type Upload struct {
+	ID       string            `json:"id"`
+	Kind     string            `json:"kind"`
+	Owner    string            `json:"owner"`
+	Created  time.Time         `json:"created"`
+	Size     int64             `json:"size"`
+	Status   string            `json:"status"`
+	MetaData map[string]string `json:"metaData"`
+	Updated  time.Time         `json:"updated"`
+	// Deleted is set when the owner deleted the upload, its files are purged after the grace period
+	Deleted time.Time `json:"deleted,omitempty"`
+	// Error tells why the processing of a failed upload failed
+	Error string `json:"error,omitempty"`
+}
+
+// PutUpload inserts or replaces an upload
+func (db *DB) PutUpload(upload Upload) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		return putUpload(tx, upload)
+	})
+}
+
+// AddUpload inserts an upload unless one with the same id is recorded already, whose state it keeps
+func (db *DB) AddUpload(upload Upload) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		if _, err := getUpload(tx, upload.ID); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return putUpload(tx, upload)
+	})
+}
+
+// GetUpload returns the upload with the given id
+func (db *DB) GetUpload(id string) (Upload, error) {
+	var upload Upload
+	err := db.bolt.View(func(tx *bbolt.Tx) error {
+		var err error
+		upload, err = getUpload(tx, id)
+		return err
+	})
+	return upload, err
+}
+
+// UpdateUpload applies fn to the upload with the given id and stores the result
+func (db *DB) UpdateUpload(id string, fn func(upload *Upload)) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		upload, err := getUpload(tx, id)
+		if err != nil {
+			return err
+		}
+		fn(&upload)
+		return putUpload(tx, upload)
+	})
+}
+
+// SetStatus records the processing status of an upload, the error of a previous failure is cleared
+// unless the upload failed again
+func (db *DB) SetStatus(id, status string) error {
+	return db.UpdateUpload(id, func(upload *Upload) {
+		upload.Status = status
+		if status != StatusFailed {
+			upload.Error = ""
+		}
+	})
+}
+
+// Fail records that the processing of an upload failed and why
+func (db *DB) Fail(id, reason string) error {
+	return db.UpdateUpload(id, func(upload *Upload) { upload.Status, upload.Error = StatusFailed, reason })
+}
+
+// MergeMetaData adds changes to the metadata of an upload
+func (db *DB) MergeMetaData(id string, changes map[string]string) error {
+	return db.UpdateUpload(id, func(upload *Upload) {
+		if upload.MetaData == nil {
+			upload.MetaData = map[string]string{}
+		}
+		for key, value := range changes {
+			upload.MetaData[key] = value
+		}
+	})
+}
+
+// DeleteUpload removes an upload together with its jobs, dead letters and derivatives, and records
+// its id as purged
+func (db *DB) DeleteUpload(id string) error {
+	return db.bolt.Update(func(tx *bbolt.Tx) error {
+		upload, err := getUpload(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(bucketUploads).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketUploadsByTime).Delete(timeKey(upload)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketUploadsByUser).Delete(ownerKey(upload)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketJobs).Delete([]byte(id)); err != nil {
+			return err
+		}
+		if err := deleteDeadLetters(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketDerivatives).Delete([]byte(id)); err != nil {
+			return err
+		}
+		purged, err := time.Now().UTC().MarshalText()
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketPurged).Put([]byte(id), purged)
+	})
+}
+
+// WasPurged reports whether the upload with the given id was recorded and then deleted by DeleteUpload.
+// The ids are kept for good, files named after them can only be left overs of the purge.
+func (db *DB) WasPurged(id string) bool {
+	purged := false
+	db.bolt.View(func(tx *bbolt.Tx) error {
+		purged = tx.Bucket(bucketPurged).Get([]byte(id)) != nil
+		return nil
+	})
+	return purged
+}
+
+// HasUpload reports whether an upload with the given id is recorded
+func (db *DB) HasUpload(id string) bool {
+	_, err := db.GetUpload(id)
+	return err == nil
+}
+
+// ForEachUpload calls fn for every upload, newest first, until fn returns false
+func (db *DB) ForEachUpload(fn func(upload Upload) bool) error {
+	return db.bolt.View(func(tx *bbolt.Tx) error {
+		uploads := tx.Bucket(bucketUploads)
+		c := tx.Bucket(bucketUploadsByTime).Cursor()
+		for k, id := c.First(); k != nil; k, id = c.Next() {
+			var upload Upload
+			if err := json.Unmarshal(uploads.Get(id), &upload); err != nil {
+				return err
+			}
+			if !fn(upload) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Query filters and pages through the uploads, empty fields match everything
+type Query struct {
+	Owner  string
+	Kind   string
+	Status string
+	From   time.Time
+	To     time.Time
+	// Tags must all be present in the upload metadata with the same value
+	Tags map[string]string
+	// Deleted lists the soft deleted uploads instead of the live ones
+	Deleted bool
+	Cursor  string
+	// Limit is the page size, defaultLimit when it is not positive
+	Limit int
+}
+
+// defaultLimit is the page size of queries that do not set one
+const defaultLimit = 20
+
+// ListUploads returns the uploads matching q, newest first, and the cursor of the next page if there is one.
+// Owner queries walk the per owner index, so they only touch that owner's uploads.
+func (db *DB) ListUploads(q Query) ([]Upload, string, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+
+	bucket, prefix := bucketUploadsByTime, []byte(nil)
+	if q.Owner != "" {
+		bucket, prefix = bucketUploadsByUser, ownerPrefix(q.Owner)
+	}
+
+	after, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil || (len(after) > 0 && !bytes.HasPrefix(after, prefix)) {
+		return nil, "", ErrInvalidCursor
+	}
+
+	var page []Upload
+	var nextCursor string
+	err = db.bolt.View(func(tx *bbolt.Tx) error {
+		uploads := tx.Bucket(bucketUploads)
+		c := tx.Bucket(bucket).Cursor()
+
+		k, id := c.Seek(prefix)
+		if len(after) > 0 {
+			// Resume right after the last key of the previous page
+			if k, id = c.Seek(after); bytes.Equal(k, after) {
+				k, id = c.Next()
+			}
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, id = c.Next() {
+			var upload Upload
+			if err := json.Unmarshal(uploads.Get(id), &upload); err != nil {
+				return err
+			}
+			if !q.matches(upload) {
+				continue
+			}
+
+			if len(page) == q.Limit {
+				nextCursor = base64.RawURLEncoding.EncodeToString(keyOf(bucket, page[len(page)-1]))
+				return nil
+			}
+			page = append(page, upload)
+		}
+		return nil
+	})
+
+	return page, nextCursor, err
+}
+
+// matches reports whether upload satisfies every filter of q
+func (q Query) matches(upload Upload) bool {
+	if q.Owner != "" && upload.Owner != q.Owner {
+		return false
+	}
+	if q.Deleted == upload.Deleted.IsZero() {
+		return false
+	}
+	if q.Kind != "" && upload.Kind != q.Kind {
+		return false
+	}
+	if q.Status != "" && upload.Status != q.Status {
+		return false
+	}
+	if !q.From.IsZero() && upload.Created.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !upload.Created.Before(q.To) {
+		return false
+	}
+	for key, value := range q.Tags {
+		if upload.MetaData[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// getUpload reads an upload inside tx
+func getUpload(tx *bbolt.Tx, id string) (Upload, error) {
+	var upload Upload
+	raw := tx.Bucket(bucketUploads).Get([]byte(id))
+	if raw == nil {
+		return upload, ErrNotFound
+	}
+	err := json.Unmarshal(raw, &upload)
+	return upload, err
+}
+
+// putUpload writes an upload and its index keys inside tx, replacing the keys of a previous version
+func putUpload(tx *bbolt.Tx, upload Upload) error {
+	if previous, err := getUpload(tx, upload.ID); err == nil {
+		if err := tx.Bucket(bucketUploadsByTime).Delete(timeKey(previous)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketUploadsByUser).Delete(ownerKey(previous)); err != nil {
+			return err
+		}
+	}
+
+	upload.Updated = time.Now().UTC()
+	raw, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(bucketUploads).Put([]byte(upload.ID), raw); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketUploadsByTime).Put(timeKey(upload), []byte(upload.ID)); err != nil {
+		return err
+	}
+	return tx.Bucket(bucketUploadsByUser).Put(ownerKey(upload), []byte(upload.ID))
+}
+
+// timeKey orders uploads newest first: the inverted creation time in big endian followed by the id
+func timeKey(upload Upload) []byte {
+	key := binary.BigEndian.AppendUint64(nil, math.MaxUint64-uint64(upload.Created.UnixNano()))
+	return append(key, upload.ID...)
+}
+
+// ownerPrefix is the common prefix of every key of an owner in the per owner index
+func ownerPrefix(owner string) []byte {
+	return append([]byte(owner), 0)
+}
+
+// ownerKey is the owner prefix followed by the time key
+func ownerKey(upload Upload) []byte {
+	return append(ownerPrefix(upload.Owner), timeKey(upload)...)
+}
+
+// keyOf returns the key of upload in the given index bucket
+func keyOf(bucket []byte, upload Upload) []byte {
+	if bytes.Equal(bucket, bucketUploadsByUser) {
+		return ownerKey(upload)
+	}
+	return timeKey(upload)
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// base is the creation time of the first test upload, the others follow a minute apart
+var base = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// putTestUploads records 30 uploads u00 to u29, created a minute apart. Every third one belongs to bob
+// and the others to alice, the even ones are videos, every fifth one failed, the first ten are tagged
+// with the trip album and u07 is deleted.
+func putTestUploads(t *testing.T, db *DB) {
+	t.Helper()
+	for i := range 30 {
+		upload := Upload{
+			ID:       fmt.Sprintf("u%02d", i),
+			Kind:     KindImage,
+			Owner:    "alice",
+			Status:   StatusReady,
+			Created:  base.Add(time.Duration(i) * time.Minute),
+			MetaData: map[string]string{},
+		}
+		if i%3 == 0 {
+			upload.Owner = "bob"
+		}
+		if i%2 == 0 {
+			upload.Kind = KindVideo
+		}
+		if i%5 == 0 {
+			upload.Status = StatusFailed
+		}
+		if i < 10 {
+			upload.MetaData["album"] = "trip"
+		}
+		if i == 7 {
+			upload.Deleted = base.Add(time.Hour)
+		}
+		if err := db.PutUpload(upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// ids returns the ids of the test uploads for which keep is true, newest first
+func ids(keep func(i int) bool) []string {
+	var ids []string
+	for i := 29; i >= 0; i-- {
+		if keep(i) {
+			ids = append(ids, fmt.Sprintf("u%02d", i))
+		}
+	}
+	return ids
+}
+
+func uploadIDs(uploads []Upload) []string {
+	ids := make([]string, 0, len(uploads))
+	for _, upload := range uploads {
+		ids = append(ids, upload.ID)
+	}
+	return ids
+}
+
+func TestListUploads(t *testing.T) {
+	db := newTestDB(t)
+	putTestUploads(t, db)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"live uploads", Query{}, ids(func(i int) bool { return i != 7 })},
+		{"owner", Query{Owner: "bob"}, ids(func(i int) bool { return i%3 == 0 })},
+		{"unknown owner", Query{Owner: "carol"}, nil},
+		{"owner prefix of another owner", Query{Owner: "ali"}, nil},
+		{"kind", Query{Kind: KindVideo}, ids(func(i int) bool { return i%2 == 0 })},
+		{"status", Query{Status: StatusFailed}, ids(func(i int) bool { return i%5 == 0 })},
+		{"owner and kind", Query{Owner: "alice", Kind: KindImage}, ids(func(i int) bool { return i%3 != 0 && i%2 == 1 && i != 7 })},
+		{"from", Query{From: base.Add(25 * time.Minute)}, ids(func(i int) bool { return i >= 25 })},
+		{"to", Query{To: base.Add(3 * time.Minute)}, ids(func(i int) bool { return i < 3 })},
+		{"time range", Query{From: base.Add(10 * time.Minute), To: base.Add(13 * time.Minute)}, ids(func(i int) bool { return i >= 10 && i < 13 })},
+		{"tags", Query{Tags: map[string]string{"album": "trip"}}, ids(func(i int) bool { return i < 10 && i != 7 })},
+		{"tag value", Query{Tags: map[string]string{"album": "work"}}, nil},
+		{"deleted", Query{Deleted: true}, []string{"u07"}},
+		{"deleted of another owner", Query{Owner: "bob", Deleted: true}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.query.Limit = 100
+			uploads, cursor, err := db.ListUploads(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := uploadIDs(uploads); !slices.Equal(got, test.want) {
+				t.Errorf("ListUploads = %v, want %v", got, test.want)
+			}
+			if cursor != "" {
+				t.Errorf("cursor %q after the last page", cursor)
+			}
+		})
+	}
+}
+
+func TestListUploadsPages(t *testing.T) {
+	db := newTestDB(t)
+	putTestUploads(t, db)
+
+	tests := []struct {
+		name      string
+		query     Query
+		wantPages []int
+		want      []string
+	}{
+		{"pages of 7", Query{Limit: 7}, []int{7, 7, 7, 7, 1}, ids(func(i int) bool { return i != 7 })},
+		{"default limit", Query{}, []int{defaultLimit, 29 - defaultLimit}, ids(func(i int) bool { return i != 7 })},
+		{"negative limit", Query{Limit: -1}, []int{defaultLimit, 29 - defaultLimit}, ids(func(i int) bool { return i != 7 })},
+		{"owner pages", Query{Owner: "alice", Limit: 5}, []int{5, 5, 5, 4}, ids(func(i int) bool { return i%3 != 0 && i != 7 })},
+		{"filtered pages", Query{Kind: KindVideo, Limit: 4}, []int{4, 4, 4, 3}, ids(func(i int) bool { return i%2 == 0 })},
+		{"exact pages", Query{Owner: "bob", Limit: 5}, []int{5, 5}, ids(func(i int) bool { return i%3 == 0 })},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			var pages []int
+			query := test.query
+			for {
+				uploads, cursor, err := db.ListUploads(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, uploadIDs(uploads)...)
+				pages = append(pages, len(uploads))
+				if cursor == "" {
+					break
+				}
+				if len(pages) > 30 {
+					t.Fatal("the cursors never reach the last page")
+				}
+				query.Cursor = cursor
+			}
+
+			if !slices.Equal(pages, test.wantPages) {
+				t.Errorf("page sizes = %v, want %v", pages, test.wantPages)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("uploads = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestListUploadsCursorSurvivesChanges(t *testing.T) {
+	db := newTestDB(t)
+	putTestUploads(t, db)
+
+	first, cursor, err := db.ListUploads(Query{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"u29", "u28", "u27"}; !slices.Equal(uploadIDs(first), want) {
+		t.Fatalf("first page = %v, want %v", uploadIDs(first), want)
+	}
+
+	// The last upload of the page is gone and a newer one arrived, the next page starts after it anyway
+	if err := db.DeleteUpload("u27"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutUpload(Upload{ID: "new", Owner: "alice", Created: base.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	next, _, err := db.ListUploads(Query{Limit: 3, Cursor: cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"u26", "u25", "u24"}; !slices.Equal(uploadIDs(next), want) {
+		t.Errorf("next page = %v, want %v", uploadIDs(next), want)
+	}
+}
+
+func TestListUploadsInvalidCursor(t *testing.T) {
+	db := newTestDB(t)
+	putTestUploads(t, db)
+
+	_, aliceCursor, err := db.ListUploads(Query{Owner: "alice", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+	}{
+		{"not base64", Query{Cursor: "not a cursor!"}},
+		{"cursor of another owner", Query{Owner: "bob", Cursor: aliceCursor}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if uploads, _, err := db.ListUploads(test.query); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("ListUploads = %v, %v, want ErrInvalidCursor", uploadIDs(uploads), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/LinuxSploit/TusAce/metrics"
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// Types of upload events, one per tusd notification channel
+type Type string
+
+const (
+	Created    Type = "created"
+	Completed  Type = "completed"
+	Progress   Type = "progress"
+	Terminated Type = "terminated"
+)
+
+// maxProgressBacklog is how many events a subscriber may have waiting before its progress events are
+// dropped. Progress is superseded by the next notification, the other events are never dropped.
+const maxProgressBacklog = 1024
+
+// Event is a tusd notification about an upload made through the handler of the given kind
+type Event struct {
+	Type Type
+	Kind string
+	Hook handler.HookEvent
+}
+
+// subscriber receives the events of its types in order, through a queue of its own
+type subscriber struct {
+	name  string
+	types []Type
+	fn    func(Event)
+
+	mu    sync.Mutex
+	queue []Event
+	wake  chan struct{}
+}
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   []*subscriber
+)
+
+// Subscribe calls fn with every event of the given types, or of every type if none is given.
+// Each subscriber runs in its own goroutine, so a slow subscriber only delays its own events and
+// never the tusd handlers or the other subscribers. A panic in fn is logged and the event skipped.
+func Subscribe(name string, fn func(Event), types ...Type) {
+	sub := &subscriber{name: name, types: types, fn: fn, wake: make(chan struct{}, 1)}
+	go sub.run()
+
+	subscribersMu.Lock()
+	subscribers = append(subscribers, sub)
+	subscribersMu.Unlock()
+}
+
+// Publish hands an event to every subscriber of its type without waiting for them
+func Publish(event Event) {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+
+	for _, sub := range subscribers {
+		if len(sub.types) == 0 || slices.Contains(sub.types, event.Type) {
+			sub.push(event)
+		}
+	}
+}
+
+// Drain reads every notification channel of a tusd handler and publishes the events. The created,
+// completed and terminated notifications of an upload are sent one after the other by the requests
+// handling it, and tusd blocks until they are read, so a single reader publishes them in that order.
+// Progress is sent from a goroutine of its own and may still arrive after the completion.
+func Drain(tusdHandler *handler.Handler, kind string) {
+	go func() {
+		for {
+			var event Event
+			select {
+			case hook := <-tusdHandler.CreatedUploads:
+				event = Event{Type: Created, Hook: hook}
+			case hook := <-tusdHandler.CompleteUploads:
+				event = Event{Type: Completed, Hook: hook}
+			case hook := <-tusdHandler.UploadProgress:
+				event = Event{Type: Progress, Hook: hook}
+			case hook := <-tusdHandler.TerminatedUploads:
+				event = Event{Type: Terminated, Hook: hook}
+			}
+			event.Kind = kind
+			Publish(event)
+		}
+	}()
+}
+
+// push queues an event for the subscriber, dropping progress events once it fell too far behind
+func (s *subscriber) push(event Event) {
+	s.mu.Lock()
+	if event.Type == Progress && len(s.queue) >= maxProgressBacklog {
+		s.mu.Unlock()
+		metrics.EventsDropped.WithLabelValues(s.name).Inc()
+		return
+	}
+	s.queue = append(s.queue, event)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the queued events to the subscriber one by one
+func (s *subscriber) run() {
+	for range s.wake {
+		for {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				s.queue = nil
+				s.mu.Unlock()
+				break
+			}
+			event := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			s.call(event)
+		}
+	}
+}
+
+// call runs the subscriber on one event, recovering from a panic
+func (s *subscriber) call(event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			metrics.EventSubscriberPanics.WithLabelValues(s.name).Inc()
+			slog.Error("event subscriber panicked", "subscriber", s.name, "event", string(event.Type), "upload_id", event.Hook.Upload.ID, "panic", r)
+		}
+	}()
+	s.fn(event)
+}
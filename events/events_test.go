@@ -0,0 +1,154 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// collect subscribes to the given types and returns a function waiting for n events
+func collect(t *testing.T, name string, types ...Type) func(n int) []Event {
+	t.Helper()
+	received := make(chan Event, 4096)
+	Subscribe(name, func(event Event) { received <- event }, types...)
+
+	return func(n int) []Event {
+		t.Helper()
+		events := make([]Event, 0, n)
+		timeout := time.After(5 * time.Second)
+		for len(events) < n {
+			select {
+			case event := <-received:
+				events = append(events, event)
+			case <-timeout:
+				t.Fatalf("received %d events, want %d", len(events), n)
+			}
+		}
+		return events
+	}
+}
+
+func TestDrainKeepsTheOrderOfAnUpload(t *testing.T) {
+	tusdHandler := &handler.Handler{UnroutedHandler: &handler.UnroutedHandler{
+		CreatedUploads:    make(chan handler.HookEvent),
+		CompleteUploads:   make(chan handler.HookEvent),
+		UploadProgress:    make(chan handler.HookEvent),
+		TerminatedUploads: make(chan handler.HookEvent),
+	}}
+	wait := collect(t, "drain-order", Created, Completed, Terminated)
+	Drain(tusdHandler, "video")
+
+	// The way tusd sends them, one blocking send after the other
+	const uploads = 200
+	for i := range uploads {
+		hook := handler.HookEvent{Upload: handler.FileInfo{ID: string(rune('a' + i%26))}}
+		tusdHandler.CreatedUploads <- hook
+		tusdHandler.CompleteUploads <- hook
+		tusdHandler.TerminatedUploads <- hook
+	}
+
+	events := wait(3 * uploads)
+	want := []Type{Created, Completed, Terminated}
+	for i, event := range events {
+		if event.Type != want[i%3] {
+			t.Fatalf("event %d is %s, want %s", i, event.Type, want[i%3])
+		}
+		if event.Kind != "video" {
+			t.Fatalf("event %d has kind %q, want video", i, event.Kind)
+		}
+	}
+}
+
+func TestSlowSubscriberDropsOnlyProgress(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var received []Event
+	done := make(chan struct{})
+	const dropped = 10
+	// Subscribers stay registered, the kind tells the events of this run from those of other runs
+	kind := fmt.Sprint(time.Now().UnixNano())
+	Subscribe("slow", func(event Event) {
+		if event.Kind != kind {
+			return
+		}
+		if len(received) == 0 {
+			close(started)
+			<-release
+		}
+		received = append(received, event)
+		if event.Type == Terminated {
+			close(done)
+		}
+	})
+
+	event := func(eventType Type, id string) Event {
+		return Event{Type: eventType, Kind: kind, Hook: handler.HookEvent{Upload: handler.FileInfo{ID: id}}}
+	}
+
+	// The subscriber is stuck on the first event while the backlog fills up with progress
+	Publish(event(Created, "slow"))
+	<-started
+	for i := range maxProgressBacklog + dropped {
+		Publish(event(Progress, string(rune('a'+i%26))))
+	}
+	// The other events are queued past the backlog limit
+	Publish(event(Completed, "slow"))
+	Publish(event(Terminated, "slow"))
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the subscriber never caught up")
+	}
+
+	if want := 1 + maxProgressBacklog + 2; len(received) != want {
+		t.Fatalf("received %d events, want %d", len(received), want)
+	}
+	for i, event := range received {
+		want := Progress
+		switch i {
+		case 0:
+			want = Created
+		case len(received) - 2:
+			want = Completed
+		case len(received) - 1:
+			want = Terminated
+		}
+		if event.Type != want {
+			t.Fatalf("event %d is %s, want %s", i, event.Type, want)
+		}
+	}
+	// The progress events kept are the oldest ones, in order
+	if got := received[maxProgressBacklog].Hook.Upload.ID; got != string(rune('a'+(maxProgressBacklog-1)%26)) {
+		t.Errorf("last progress event kept is for %s", got)
+	}
+}
+
+func TestSubscriberPanicSkipsTheEvent(t *testing.T) {
+	wait := collect(t, "panic-witness", Created)
+	received := make(chan string, 2)
+	Subscribe("panicking", func(event Event) {
+		if event.Hook.Upload.ID == "bad" {
+			panic("broken subscriber")
+		}
+		received <- event.Hook.Upload.ID
+	}, Created)
+
+	Publish(Event{Type: Created, Hook: handler.HookEvent{Upload: handler.FileInfo{ID: "bad"}}})
+	Publish(Event{Type: Created, Hook: handler.HookEvent{Upload: handler.FileInfo{ID: "good"}}})
+
+	select {
+	case id := <-received:
+		if id != "good" {
+			t.Errorf("received %s, want good", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the subscriber stopped after the panic")
+	}
+	// The other subscribers received both events
+	if events := wait(2); events[0].Hook.Upload.ID != "bad" || events[1].Hook.Upload.ID != "good" {
+		t.Errorf("witness received %s and %s, want bad and good", events[0].Hook.Upload.ID, events[1].Hook.Upload.ID)
+	}
+}
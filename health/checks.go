@@ -0,0 +1,159 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/middleware"
+	"github.com/LinuxSploit/TusAce/storage"
+	"github.com/LinuxSploit/TusAce/transcoder"
+	"github.com/LinuxSploit/TusAce/utils"
+)
+
+// requiredEncoders are the ffmpeg encoders run.sh uses
+var requiredEncoders = []string{"libx264", "aac"}
+
+// ffmpegReady remembers a successful ffmpeg check, the binaries do not change while the process runs
+var (
+	ffmpegMu    sync.Mutex
+	ffmpegReady bool
+)
+
+// checks returns the readiness checks, each may report several outcomes
+func checks() []func(ctx context.Context) []Check {
+	return []func(ctx context.Context) []Check{
+		checkStorage,
+		checkBackend,
+		checkFFmpeg,
+		checkQueues,
+		checkAuth,
+	}
+}
+
+// checkStorage verifies that every storage directory is writable and has config.ReadyMinFreeBytes free
+func checkStorage(ctx context.Context) []Check {
+	dirs := []struct{ name, path string }{
+		{"videos", config.VideosDir},
+		{"images", config.ImagesDir},
+		{"hls", config.HLSDir},
+		{"thumbnail", config.ThumbnailDir},
+		{"staging", config.StagingDir},
+	}
+	if config.OriginalRetention == "archive" {
+		dirs = append(dirs, struct{ name, path string }{"archive", config.ArchiveDir})
+	}
+
+	var outcomes []Check
+	for _, dir := range dirs {
+		free, err := checkDir(dir.path)
+		outcomes = append(outcomes, result("storage:"+dir.name, fmt.Sprintf("%d bytes free", free), err))
+	}
+	return outcomes
+}
+
+// checkBackend verifies that the storage backend is reachable
+func checkBackend(ctx context.Context) []Check {
+	return []Check{result("storage:backend", config.StorageBackend, storage.Default.Check(ctx))}
+}
+
+// checkDir writes and removes a probe file in dir and returns the free space of its file system
+func checkDir(dir string) (uint64, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	probe, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return 0, fmt.Errorf("not writable: %w", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	free, err := utils.FreeSpace(dir)
+	if err != nil {
+		return 0, err
+	}
+	if free < uint64(config.ReadyMinFreeBytes) {
+		return free, fmt.Errorf("less than %d bytes free", config.ReadyMinFreeBytes)
+	}
+	return free, nil
+}
+
+// checkFFmpeg verifies that ffmpeg and ffprobe are installed and that ffmpeg has the encoders run.sh uses
+func checkFFmpeg(ctx context.Context) []Check {
+	ffmpegMu.Lock()
+	defer ffmpegMu.Unlock()
+	if ffmpegReady {
+		return []Check{result("ffmpeg", "", nil), result("ffprobe", "", nil)}
+	}
+
+	ffprobe := result("ffprobe", "", lookPath("ffprobe"))
+
+	err := lookPath("ffmpeg")
+	if err == nil {
+		var out []byte
+		out, err = exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-encoders").Output()
+		if err == nil {
+			err = missingEncoders(string(out))
+		}
+	}
+	ffmpeg := result("ffmpeg", "encoders "+strings.Join(requiredEncoders, ", "), err)
+
+	ffmpegReady = ffmpeg.OK && ffprobe.OK
+	return []Check{ffmpeg, ffprobe}
+}
+
+// lookPath reports whether the binary is found in PATH
+func lookPath(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+// missingEncoders checks the output of ffmpeg -encoders for the required encoders. Every encoder is
+// listed on its own line, after a column of capability flags.
+func missingEncoders(list string) error {
+	available := map[string]bool{}
+	for _, line := range strings.Split(list, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			available[fields[1]] = true
+		}
+	}
+
+	var missing []string
+	for _, encoder := range requiredEncoders {
+		if !available[encoder] {
+			missing = append(missing, encoder)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing encoders %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkQueues verifies that the transcode queues are filled below config.ReadyQueueThreshold
+func checkQueues(ctx context.Context) []Check {
+	return []Check{
+		checkQueue("queue:transcode", len(transcoder.TranscodeQueue), cap(transcoder.TranscodeQueue)),
+		checkQueue("queue:retranscode", len(transcoder.RetranscodeQueue), cap(transcoder.RetranscodeQueue)),
+	}
+}
+
+// checkQueue reports a queue as saturated once its length reaches the threshold of its capacity
+func checkQueue(name string, length, capacity int) Check {
+	var err error
+	if float64(length) >= config.ReadyQueueThreshold*float64(capacity) {
+		err = errors.New("saturated")
+	}
+	return result(name, fmt.Sprintf("%d of %d", length, capacity), err)
+}
+
+// checkAuth verifies that the auth service validating sessions is reachable
+func checkAuth(ctx context.Context) []Check {
+	return []Check{result("auth", "", middleware.CheckAuthBackend(ctx))}
+}
@@ -0,0 +1,92 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds a whole readiness run, so that a hanging dependency fails the probe instead of blocking it
+const checkTimeout = 5 * time.Second
+
+// Check is the outcome of one readiness check
+type Check struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of /healthz and /readyz
+type Report struct {
+	Status string  `json:"status"`
+	Checks []Check `json:"checks,omitempty"`
+}
+
+// LivenessHandler serves GET /healthz, it only tells that the process answers
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Report{Status: "ok"})
+}
+
+// ReadinessHandler serves GET /readyz. It runs every check and answers 503 with the breakdown
+// when one of them fails, so that a deploy of a broken image never takes traffic.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	report := Run(ctx)
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// Run runs the readiness checks concurrently and collects their outcomes in a stable order
+func Run(ctx context.Context) Report {
+	var groups [][]Check
+	var wg sync.WaitGroup
+	for _, check := range checks() {
+		groups = append(groups, nil)
+		i := len(groups) - 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			groups[i] = check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok"}
+	for _, group := range groups {
+		for _, check := range group {
+			if !check.OK {
+				report.Status = "fail"
+			}
+			report.Checks = append(report.Checks, check)
+		}
+	}
+	return report
+}
+
+// result turns the error of a check into its outcome
+func result(name, detail string, err error) Check {
+	check := Check{Name: name, OK: err == nil, Detail: detail}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", "error", err)
+	}
+}
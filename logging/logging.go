@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	expslog "golang.org/x/exp/slog"
+)
+
+// redacted replaces the value of secret attributes
+const redacted = "[REDACTED]"
+
+// secretKeys are attribute keys whose values never reach the logs, compared case-insensitively
+var secretKeys = map[string]bool{
+	"authorization": true,
+	"session_token": true,
+	"sessiontoken":  true,
+	"token":         true,
+	"admin_token":   true,
+	"password":      true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+// level is the minimum level set by Setup
+var level = new(slog.LevelVar)
+
+// Context keys holding the ids the logs of a request or a job are correlated with
+type (
+	requestIDKey struct{}
+	uploadIDKey  struct{}
+)
+
+// Setup makes a JSON logger writing to stdout the default logger, also for the standard log package.
+// level is one of debug, info, warn or error, invalid levels fall back to info.
+func Setup(name string) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(name)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	level.Set(lvl)
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})
+	slog.SetDefault(slog.New(handler))
+	// Messages of dependencies still using the log package are written at info level
+	log.SetFlags(0)
+
+	if !strings.EqualFold(lvl.String(), name) {
+		slog.Warn("invalid log level, using info", "level", name)
+	}
+}
+
+// TusdLogger returns a logger for the tusd handlers, which log through golang.org/x/exp/slog.
+// It writes the same JSON to stdout at the level set by Setup.
+func TusdLogger() *expslog.Logger {
+	return expslog.New(expslog.NewJSONHandler(os.Stdout, &expslog.HandlerOptions{
+		Level: expslog.Level(level.Level()),
+		ReplaceAttr: func(_ []string, attr expslog.Attr) expslog.Attr {
+			if secretKeys[strings.ToLower(attr.Key)] {
+				return expslog.String(attr.Key, redacted)
+			}
+			return attr
+		},
+	}))
+}
+
+// redact hides the values of secret attributes, including those nested in groups such as headers
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+	return attr
+}
+
+// WithRequestID returns a copy of ctx carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id carried by ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithUploadID returns a copy of ctx carrying the id of the upload being processed
+func WithUploadID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, uploadIDKey{}, id)
+}
+
+// FromContext returns the default logger, annotated with the request and upload ids carried by ctx
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if id, _ := ctx.Value(uploadIDKey{}).(string); id != "" {
+		logger = logger.With("upload_id", id)
+	}
+	return logger
+}
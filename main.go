@@ -1,59 +1,135 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"html/template"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"path"
-
-	"github.com/LinuxSploit/TusAce/debug"
+	"os/signal"
+	"syscall"
+
+	"github.com/LinuxSploit/TusAce/admin"
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/health"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/media"
+	"github.com/LinuxSploit/TusAce/metrics"
 	"github.com/LinuxSploit/TusAce/middleware"
+	"github.com/LinuxSploit/TusAce/progress"
+	"github.com/LinuxSploit/TusAce/storage"
 	"github.com/LinuxSploit/TusAce/transcoder"
 	"github.com/LinuxSploit/TusAce/tus"
+	"github.com/LinuxSploit/TusAce/webhook"
+	"github.com/tus/tusd/v2/pkg/handler"
 )
 
 func init() {
-	err := os.MkdirAll("/storage/tus/thumbnail/", os.ModePerm)
+	logging.Setup(config.LogLevel)
+
+	err := os.MkdirAll(config.ThumbnailDir, os.ModePerm)
 	if err != nil {
-		log.Fatal(err)
+		fatal("unable to create thumbnail directory", err)
 	}
-	log.Println("init loaded successfully!")
 }
 
 func main() {
-	log.Println("Service started")
-	// Create TUS video Upload handler, init basePath, storageDir
-	videoHandler, err := tus.SetupTusVideoHandler("https://tus-server-production.up.railway.app/video/", "/storage/tus/videos")
+	slog.Info("service started")
+
+	// Open the database and record uploads that predate it, the tus events and the transcoder keep it current afterwards
+	database, err := db.Open(config.DatabasePath)
 	if err != nil {
-		log.Fatalf("Unable to create photo handler: %v", err)
+		fatal("unable to open database", err)
 	}
+	defer database.Close()
+	db.Default = database
+
+	// Store uploads and serve the published media locally or from S3, depending on config.StorageBackend
+	backend, err := storage.Open(context.Background())
+	if err != nil {
+		fatal("unable to open storage backend", err)
+	}
+	storage.Default = backend
+
+	if err := media.ImportUploads(context.Background()); err != nil {
+		fatal("unable to import uploads", err)
+	}
+
+	// Log, count, index, announce and transcode the uploads made through both handlers
+	tus.SubscribeUploadEvents()
 
-	// Create TUS video Upload handler, init basePath, storageDir
-	imageHandler, err := tus.SetupTusImageHandler("https://tus-server-production.up.railway.app/image/", "/storage/tus/images")
+	// Create TUS video Upload handler, init basePath
+	videoHandler, err := tus.SetupTusVideoHandler(config.PublicBaseURL + "/video/")
 	if err != nil {
-		log.Fatalf("Unable to create photo handler: %v", err)
+		fatal("unable to create video handler", err)
+	}
+
+	// Create TUS image Upload handler, init basePath
+	imageHandler, err := tus.SetupTusImageHandler(config.PublicBaseURL + "/image/")
+	if err != nil {
+		fatal("unable to create photo handler", err)
+	}
+
+	// Refuse to send webhooks receivers cannot verify
+	if err := webhook.Validate(); err != nil {
+		fatal("invalid webhook configuration, set WEBHOOK_SECRET", err)
+	}
+
+	// Refuse to send credentials to any origin, any site could then act on behalf of the users
+	if err := middleware.UploadCORS.Validate(); err != nil {
+		fatal("invalid cors policy of the upload routes, set CORS_UPLOAD_ORIGINS", err)
+	}
+	if err := middleware.APICORS.Validate(); err != nil {
+		fatal("invalid cors policy of the media api, set CORS_API_ORIGINS", err)
 	}
 
 	mux := http.NewServeMux()
 
 	// Register TUS video Upload handler to /upload/ route
-	mux.Handle("/video/", http.StripPrefix("/video/", videoHandler))
+	mux.Handle("/video/", http.StripPrefix("/video/", metrics.TusMiddleware(db.KindVideo, tus.TerminationMiddleware(tus.ExpirationMiddleware(videoHandler)))))
 	// Serve HLS video streams with CORS middleware
-	videoFileServer := http.StripPrefix("/hls/", NoDirListingFileServer(http.Dir("/storage/tus/hls/")))
-	mux.Handle("/hls/", middleware.CORSMiddleware(videoFileServer))
+	videoFileServer := http.StripPrefix("/hls/", media.HideDeleted(storage.HLS, storage.FileServer(storage.Default.FileSystem(storage.HLS))))
+	mux.Handle("/hls/", middleware.PlaybackCORS.Handler(videoFileServer))
 	//thumbnail server
-	thumbnailFileServer := http.StripPrefix("/thumbnail/", NoDirListingFileServer(http.Dir("/storage/tus/thumbnail/")))
-	mux.Handle("/thumbnail/", middleware.CORSMiddleware(thumbnailFileServer))
+	thumbnailFileServer := http.StripPrefix("/thumbnail/", media.HideDeleted(storage.Thumbnail, storage.FileServer(storage.Default.FileSystem(storage.Thumbnail))))
+	mux.Handle("/thumbnail/", middleware.PlaybackCORS.Handler(thumbnailFileServer))
 
 	// Register TUS image Upload handler to /image-upload/ route
-	mux.Handle("/image/", http.StripPrefix("/image/", imageHandler))
-
-	mux.HandleFunc("/media", func(w http.ResponseWriter, r *http.Request) {
-		id := r.URL.Query().Get("id")
-		fmt.Fprintln(w, id)
-	})
+	mux.Handle("/image/", http.StripPrefix("/image/", metrics.TusMiddleware(db.KindImage, tus.TerminationMiddleware(tus.ExpirationMiddleware(imageHandler)))))
+
+	// Media API: the caller's library listing, and status and derivatives of a single upload
+	mux.Handle("GET /media", middleware.APICORS.Handler(middleware.RequireSession(http.HandlerFunc(media.ListHandler))))
+	mux.Handle("GET /media/{id}", middleware.APICORS.Handler(middleware.RequireSession(http.HandlerFunc(media.InfoHandler))))
+	mux.Handle("GET /media/events", middleware.APICORS.Handler(middleware.RequireSession(http.HandlerFunc(progress.StreamHandler))))
+	mux.Handle("DELETE /media/{id}", middleware.APICORS.Handler(middleware.RequireSession(http.HandlerFunc(media.DeleteHandler))))
+	mux.Handle("POST /media/{id}/restore", middleware.APICORS.Handler(middleware.RequireSession(http.HandlerFunc(media.RestoreHandler))))
+	// Answer CORS preflights of the media API, they are sent because of the session headers
+	mux.Handle("OPTIONS /media/", middleware.APICORS.Handler(http.NotFoundHandler()))
+	mux.Handle("OPTIONS /media", middleware.APICORS.Handler(http.NotFoundHandler()))
+
+	// Admin API, authenticated with config.AdminToken
+	mux.Handle("GET /admin/storage", middleware.RequireAdmin(http.HandlerFunc(admin.StorageHandler)))
+	mux.Handle("POST /admin/cleanup", middleware.RequireAdmin(http.HandlerFunc(admin.CleanupHandler)))
+	mux.Handle("GET /admin/uploads", middleware.RequireAdmin(http.HandlerFunc(admin.UploadsHandler)))
+	mux.Handle("GET /admin/uploads/{id}", middleware.RequireAdmin(http.HandlerFunc(admin.UploadHandler)))
+	mux.Handle("GET /admin/jobs", middleware.RequireAdmin(http.HandlerFunc(admin.JobsHandler)))
+	mux.Handle("POST /admin/retranscode", middleware.RequireAdmin(http.HandlerFunc(admin.RetranscodeHandler)))
+	mux.Handle("GET /admin/jobs/failed", middleware.RequireAdmin(http.HandlerFunc(admin.FailedJobsHandler)))
+	mux.Handle("POST /admin/jobs/{id}/requeue", middleware.RequireAdmin(http.HandlerFunc(admin.RequeueHandler)))
+	mux.Handle("POST /admin/jobs/{id}/cancel", middleware.RequireAdmin(http.HandlerFunc(admin.CancelHandler)))
+	mux.Handle("GET /admin/webhooks/deliveries", middleware.RequireAdmin(http.HandlerFunc(admin.DeliveriesHandler)))
+
+	// Liveness and readiness probes, /readyz checks storage, ffmpeg, the transcode queues and the auth service
+	mux.HandleFunc("GET /healthz", health.LivenessHandler)
+	mux.HandleFunc("GET /readyz", health.ReadinessHandler)
+
+	// Prometheus metrics of uploads, storage and transcoding
+	metrics.RegisterQueueDepth("transcode", func() int { return len(transcoder.TranscodeQueue) })
+	metrics.RegisterQueueDepth("retranscode", func() int { return len(transcoder.RetranscodeQueue) })
+	mux.Handle("GET /metrics", metrics.Handler())
 
 	// Serve the home page with demo upload page
 	mux.HandleFunc("/video-demo", func(w http.ResponseWriter, r *http.Request) {
@@ -66,48 +142,63 @@ func main() {
 		tmpl.Execute(w, nil)
 	})
 
-	// mux.Handle("/geoip", middleware.CORSMiddleware(http.HandlerFunc(geoip.GeoIP)))
+	// mux.Handle("/geoip", middleware.APICORS.Handler(http.HandlerFunc(geoip.GeoIP)))
 
-	mux.HandleFunc("/ls", debug.DebugFilesListHandler)
+	// Expire abandoned uploads, purge deleted media and remove orphaned derivatives
+	media.StartJanitor(config.JanitorInterval)
 
-	// Start the transcode worker
-	transcoder.StartTranscodeWorker("/storage/tus/videos/", "/storage/tus/hls/")
+	// Start the transcode worker and pick up the jobs the previous run left queued
+	transcoder.StartTranscodeWorker(config.VideosDir, config.HLSDir)
+	if err := transcoder.ResumeJobs(); err != nil {
+		slog.Error("unable to resume transcode jobs", "error", err)
+	}
 
-	// Start the HTTP server
-	if err := http.ListenAndServe("0.0.0.0:8080", mux); err != nil {
-		log.Fatalf("Unable to start server: %v", err)
+	// Retry the webhook deliveries the previous run left pending
+	if err := webhook.ResumeDeliveries(); err != nil {
+		slog.Error("unable to resume webhook deliveries", "error", err)
 	}
-}
 
-// NoDirListingFileServer wraps the http.FileServer to disable directory listings
-func NoDirListingFileServer(root http.FileSystem) http.Handler {
-	fs := http.FileServer(root)
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the absolute path to prevent directory traversal
-		upath := path.Clean(r.URL.Path)
-
-		// Open the file
-		f, err := root.Open(upath)
-		if err != nil {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		defer f.Close()
+	// Shut down gracefully on SIGINT or SIGTERM, a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Like tusd itself, interrupt uploading PATCH requests on shutdown: tusd saves the data received
+	// so far and answers 503, the clients resume the upload later
+	serverCtx, cancelServerCtx := context.WithCancelCause(context.Background())
+	server := &http.Server{
+		Addr:        "0.0.0.0:8080",
+		Handler:     middleware.RequestID(mux),
+		BaseContext: func(net.Listener) context.Context { return serverCtx },
+	}
+	server.RegisterOnShutdown(func() { cancelServerCtx(handler.ErrServerShutdown) })
 
-		// Get file information
-		info, err := f.Stat()
-		if err != nil {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
+	// Start the HTTP server
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("unable to start server", err)
 		}
+	}()
 
-		// If it's a directory, deny access
-		if info.IsDir() {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
+	<-ctx.Done()
+	stop()
+	slog.Info("shutting down")
 
-		// Serve the file
-		fs.ServeHTTP(w, r)
-	})
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	tus.StopAcceptingUploads()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("requests did not finish in time", "error", err)
+	}
+	if err := transcoder.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("transcode interrupted and queued again", "error", err)
+	}
+
+	slog.Info("service stopped")
+}
+
+// fatal logs an error that keeps the service from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,131 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/storage"
+	"github.com/LinuxSploit/TusAce/transcoder"
+	"github.com/LinuxSploit/TusAce/utils"
+	"github.com/LinuxSploit/TusAce/webhook"
+)
+
+// ErrNotDeleted is returned when restoring an upload that is not deleted
+var ErrNotDeleted = errors.New("media is not deleted")
+
+// Delete marks an upload deleted and stops its transcode. Its files are purged once
+// config.DeleteGracePeriod has passed, or right away if there is no grace period.
+func Delete(id string) (time.Time, error) {
+	deleted := time.Now().UTC()
+	var record db.Upload
+	err := db.Default.UpdateUpload(id, func(upload *db.Upload) {
+		if upload.Deleted.IsZero() {
+			upload.Deleted = deleted
+		}
+		record = *upload
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	webhook.Publish(webhook.EventMediaDeleted, record, nil)
+
+	if transcoder.Cancel(id) {
+		slog.Info("cancelled transcoding of deleted upload", "upload_id", id)
+	}
+
+	if config.DeleteGracePeriod <= 0 {
+		_, err := Purge(id)
+		return deleted, err
+	}
+	return deleted.Add(config.DeleteGracePeriod), nil
+}
+
+// Restore brings back an upload deleted within the grace period. A video whose transcode was
+// interrupted by the deletion is queued again.
+func Restore(id string) error {
+	var upload db.Upload
+	err := db.Default.UpdateUpload(id, func(u *db.Upload) {
+		upload = *u
+		u.Deleted = time.Time{}
+	})
+	if err != nil {
+		return err
+	}
+	if upload.Deleted.IsZero() {
+		return ErrNotDeleted
+	}
+
+	if upload.Kind == db.KindVideo && upload.Status == db.StatusProcessing {
+		if job, err := db.Default.GetJob(id, db.JobTranscode); err == nil && job.Status == db.JobCancelled {
+			go transcoder.Enqueue(id)
+		}
+	}
+
+	return nil
+}
+
+// Purge removes the original upload, its HLS output, its thumbnails and its database record, from the
+// disk and the storage backend. It returns the number of bytes freed on disk.
+func Purge(id string) (int64, error) {
+	if !validID.MatchString(id) {
+		return 0, ErrNotFound
+	}
+
+	upload, err := db.Default.GetUpload(id)
+	if err != nil {
+		return 0, err
+	}
+
+	derivatives, err := db.Default.Derivatives(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	var reclaimed int64
+	for _, path := range Paths(upload, derivatives) {
+		size := utils.DirSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		reclaimed += size
+	}
+	// Unpublish the outputs and drop the upload from the storage backend
+	ctx := context.Background()
+	errs = append(errs,
+		storage.Default.Remove(ctx, storage.HLS, id+"/"),
+		storage.Default.Remove(ctx, storage.Thumbnail, id+"-"),
+		storage.Default.RemoveUpload(ctx, upload.Kind, id),
+	)
+	if err := errors.Join(errs...); err != nil {
+		// Keep the record so the purge is retried
+		return reclaimed, err
+	}
+
+	slog.Info("purged media", "upload_id", id, "reclaimed_bytes", reclaimed)
+	return reclaimed, db.Default.DeleteUpload(id)
+}
+
+// Paths returns the files and directories an upload occupies on disk, some of which may not exist
+func Paths(upload db.Upload, derivatives []db.Derivative) []string {
+	// The tusd files: the data, its .info and the lock file of the filelocker
+	uploadDir := config.ImagesDir
+	if upload.Kind == db.KindVideo {
+		uploadDir = config.VideosDir
+	}
+	id := upload.ID
+	paths := []string{uploadDir + id, uploadDir + id + ".info", uploadDir + id + ".lock", config.HLSDir + id}
+	for _, derivative := range derivatives {
+		paths = append(paths, derivative.Path)
+	}
+	// Also catch thumbnails written by variants that have since been removed from the configuration
+	thumbnails, _ := filepath.Glob(config.ThumbnailDir + id + "-*.webp")
+	return append(paths, thumbnails...)
+}
@@ -0,0 +1,206 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/middleware"
+)
+
+// Page sizes of the library listing
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// ListResponse is one page of the library listing
+type ListResponse struct {
+	Items      []*Info `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// DeleteResponse confirms a deletion and tells until when it can be restored
+type DeleteResponse struct {
+	ID         string    `json:"id"`
+	PurgeAfter time.Time `json:"purgeAfter"`
+}
+
+// InfoHandler serves GET /media/{id} with the Info of an upload of the authenticated principal as JSON
+func InfoHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := LoadInfo(upload.ID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to load media", "upload_id", r.PathValue("id"), "error", err)
+		http.Error(w, "Failed to load media", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+// ListHandler serves GET /media, paging through the uploads of the authenticated principal.
+// Supported filters: owner=me, kind, status, from and to (RFC 3339 or YYYY-MM-DD, on the creation
+// date), tag=key:value (repeatable, matched against the upload metadata), deleted=true to list the
+// uploads that can still be restored, cursor and limit.
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	// Only the caller's own library can be listed
+	principal := middleware.Principal(r.Context())
+	if owner := params.Get("owner"); owner != "" && owner != "me" && owner != principal {
+		http.Error(w, "Listing another owner's media is not allowed", http.StatusForbidden)
+		return
+	}
+
+	query := db.Query{
+		Owner:   principal,
+		Kind:    params.Get("kind"),
+		Status:  params.Get("status"),
+		Tags:    map[string]string{},
+		Deleted: params.Get("deleted") == "true",
+		Cursor:  params.Get("cursor"),
+		Limit:   defaultListLimit,
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = min(parsed, maxListLimit)
+	}
+
+	var err error
+	if query.From, err = parseDateParam(params.Get("from")); err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	if query.To, err = parseDateParam(params.Get("to")); err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+
+	for _, tag := range params["tag"] {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || key == "" {
+			http.Error(w, "Invalid tag, expected key:value", http.StatusBadRequest)
+			return
+		}
+		query.Tags[key] = value
+	}
+
+	uploads, nextCursor, err := db.Default.ListUploads(query)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list media", "error", err)
+		http.Error(w, "Failed to list media", http.StatusInternalServerError)
+		return
+	}
+
+	response := ListResponse{Items: make([]*Info, 0, len(uploads)), NextCursor: nextCursor}
+	for _, upload := range uploads {
+		response.Items = append(response.Items, infoFromUpload(upload))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// DeleteHandler serves DELETE /media/{id}, it soft deletes an upload of the authenticated principal
+func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := ownedUpload(w, r)
+	if !ok {
+		return
+	}
+	if !upload.Deleted.IsZero() {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	purgeAfter, err := Delete(upload.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to delete media", "upload_id", upload.ID, "error", err)
+		http.Error(w, "Failed to delete media", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, DeleteResponse{ID: upload.ID, PurgeAfter: purgeAfter})
+}
+
+// RestoreHandler serves POST /media/{id}/restore, it undoes a deletion still within its grace period
+func RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	upload, ok := ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	err := Restore(upload.ID)
+	if errors.Is(err, ErrNotDeleted) {
+		http.Error(w, "Media is not deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to restore media", "upload_id", upload.ID, "error", err)
+		http.Error(w, "Failed to restore media", http.StatusInternalServerError)
+		return
+	}
+
+	InfoHandler(w, r)
+}
+
+// ownedUpload loads the upload named in the path and checks that the authenticated principal owns it,
+// writing the error response otherwise. Uploads of other owners are reported as missing.
+func ownedUpload(w http.ResponseWriter, r *http.Request) (db.Upload, bool) {
+	id := r.PathValue("id")
+	upload, err := db.Default.GetUpload(id)
+	if err == nil && upload.Owner == middleware.Principal(r.Context()) {
+		return upload, true
+	}
+
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		logging.FromContext(r.Context()).Error("failed to load media", "upload_id", id, "error", err)
+		http.Error(w, "Failed to load media", http.StatusInternalServerError)
+		return upload, false
+	}
+
+	http.Error(w, "Media not found", http.StatusNotFound)
+	return upload, false
+}
+
+// parseDateParam parses an RFC 3339 timestamp or a plain date, an empty value yields the zero time
+func parseDateParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", "error", err)
+	}
+}
@@ -0,0 +1,27 @@
+package media
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/LinuxSploit/TusAce/db"
+)
+
+func TestListHandlerErrors(t *testing.T) {
+	useTempStorage(t)
+
+	rec := httptest.NewRecorder()
+	ListHandler(rec, httptest.NewRequest(http.MethodGet, "/media?cursor=not-a-cursor!", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("listing with an invalid cursor answered %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// A database failure is not the caller's fault
+	db.Default.Close()
+	rec = httptest.NewRecorder()
+	ListHandler(rec, httptest.NewRequest(http.MethodGet, "/media", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("listing with the database closed answered %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
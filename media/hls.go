@@ -0,0 +1,106 @@
+package media
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Rendition is one variant stream listed in an HLS master playlist
+type Rendition struct {
+	Name      string `json:"name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Bandwidth int    `json:"bandwidth"`
+	URL       string `json:"url"`
+
+	// playlist is the variant playlist path relative to the master playlist
+	playlist string
+}
+
+// parseMasterPlaylist reads the variant streams out of an HLS master playlist
+func parseMasterPlaylist(masterPath string) ([]Rendition, error) {
+	file, err := os.Open(masterPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var renditions []Rendition
+	var pending *Rendition
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if attrs, ok := strings.CutPrefix(line, "#EXT-X-STREAM-INF:"); ok {
+			pending = &Rendition{}
+			for _, attr := range splitAttributes(attrs) {
+				key, value, _ := strings.Cut(attr, "=")
+				switch key {
+				case "BANDWIDTH":
+					pending.Bandwidth, _ = strconv.Atoi(value)
+				case "RESOLUTION":
+					width, height, _ := strings.Cut(value, "x")
+					pending.Width, _ = strconv.Atoi(width)
+					pending.Height, _ = strconv.Atoi(height)
+				}
+			}
+			continue
+		}
+
+		// The URI line following EXT-X-STREAM-INF names the variant playlist
+		if pending != nil && line != "" && !strings.HasPrefix(line, "#") {
+			pending.playlist = line
+			pending.Name = strings.TrimSuffix(path.Base(line), ".m3u8")
+			renditions = append(renditions, *pending)
+			pending = nil
+		}
+	}
+
+	return renditions, scanner.Err()
+}
+
+// playlistDuration sums the segment durations of an HLS media playlist, in seconds
+func playlistDuration(playlistPath string) (float64, error) {
+	file, err := os.Open(playlistPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	duration := 0.0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "#EXTINF:"); ok {
+			value, _, _ = strings.Cut(value, ",")
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				duration += seconds
+			}
+		}
+	}
+
+	return duration, scanner.Err()
+}
+
+// splitAttributes splits an HLS attribute list on commas that are not inside quoted strings
+func splitAttributes(attrs string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i, c := range attrs {
+		switch c {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, attrs[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, attrs[start:])
+}
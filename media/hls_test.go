@@ -0,0 +1,76 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseMasterPlaylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+		want     []Rendition
+	}{
+		{
+			name: "ffmpeg output",
+			playlist: "#EXTM3U\n#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=842x480\n480p.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720\n720p.m3u8\n",
+			want: []Rendition{
+				{Name: "480p", Width: 842, Height: 480, Bandwidth: 1400000, playlist: "480p.m3u8"},
+				{Name: "720p", Width: 1280, Height: 720, Bandwidth: 2800000, playlist: "720p.m3u8"},
+			},
+		},
+		{
+			name: "quoted codecs and a version directory",
+			playlist: "#EXTM3U\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=5000000,CODECS=\"avc1.640028,mp4a.40.2\",RESOLUTION=1920x1080\n" +
+				"v2/1080p.m3u8\n",
+			want: []Rendition{{Name: "1080p", Width: 1920, Height: 1080, Bandwidth: 5000000, playlist: "v2/1080p.m3u8"}},
+		},
+		{
+			name: "comments and blank lines before the URI, CRLF line endings",
+			playlist: "#EXTM3U\r\n" +
+				"#EXT-X-STREAM-INF:RESOLUTION=640x360,BANDWIDTH=800000\r\n\r\n# low\r\n360p.m3u8\r\n",
+			want: []Rendition{{Name: "360p", Width: 640, Height: 360, Bandwidth: 800000, playlist: "360p.m3u8"}},
+		},
+		{
+			name:     "missing attributes",
+			playlist: "#EXTM3U\n#EXT-X-STREAM-INF:PROGRAM-ID=1\naudio.m3u8\n",
+			want:     []Rendition{{Name: "audio", playlist: "audio.m3u8"}},
+		},
+		{
+			name:     "URI without a stream",
+			playlist: "#EXTM3U\nstray.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=1\n",
+			want:     nil,
+		},
+		{
+			name:     "media playlist",
+			playlist: "#EXTM3U\n#EXTINF:4.0,\n720p_000.ts\n#EXT-X-ENDLIST\n",
+			want:     nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "master.m3u8")
+			if err := os.WriteFile(path, []byte(test.playlist), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			renditions, err := parseMasterPlaylist(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(renditions, test.want) {
+				t.Errorf("parseMasterPlaylist = %+v, want %+v", renditions, test.want)
+			}
+		})
+	}
+
+	if _, err := parseMasterPlaylist(filepath.Join(t.TempDir(), "missing.m3u8")); err == nil {
+		t.Error("parseMasterPlaylist of a missing file succeeded")
+	}
+}
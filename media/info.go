@@ -0,0 +1,162 @@
+package media
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"slices"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+)
+
+// ErrNotFound is returned when no upload exists for an id
+var ErrNotFound = errors.New("media not found")
+
+// validID matches the ids generated by tusd, <object id>+<multipart id> for s3store, it keeps ids
+// from escaping the storage directories
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+(\+[A-Za-z0-9_.=-]+)?$`)
+
+// Info describes an upload and everything derived from it
+type Info struct {
+	ID            string     `json:"id"`
+	Kind          string     `json:"kind"`
+	Owner         string     `json:"owner"`
+	CreatedDate   string     `json:"createdDate"`
+	Filename      string     `json:"filename"`
+	Size          int64      `json:"size"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	BlurHash      string     `json:"blurhash,omitempty"`
+	LQIP          string     `json:"lqip,omitempty"`
+	DominantColor string     `json:"dominantColor,omitempty"`
+	Video         *VideoInfo `json:"video,omitempty"`
+	Image         *ImageInfo `json:"image,omitempty"`
+}
+
+// VideoInfo describes the HLS output of a transcoded video
+type VideoInfo struct {
+	Duration          float64           `json:"duration"`
+	Width             int               `json:"width"`
+	Height            int               `json:"height"`
+	Renditions        []Rendition       `json:"renditions"`
+	MasterPlaylistURL string            `json:"masterPlaylistUrl"`
+	PosterURLs        map[string]string `json:"posterUrls"`
+}
+
+// ImageInfo lists the WebP variants generated for an image
+type ImageInfo struct {
+	Variants []Variant `json:"variants"`
+}
+
+// Variant is one generated WebP file
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// LoadInfo builds the Info of an upload from its database record and the transcoder outputs
+func LoadInfo(id string) (*Info, error) {
+	if !validID.MatchString(id) {
+		return nil, ErrNotFound
+	}
+
+	upload, err := db.Default.GetUpload(id)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !upload.Deleted.IsZero()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := infoFromUpload(upload)
+	if info.Status != db.StatusReady {
+		return info, nil
+	}
+
+	derivatives, err := db.Default.Derivatives(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.Kind == db.KindVideo {
+		info.Video = loadVideo(id, derivatives)
+	} else {
+		info.Image = loadImage(derivatives)
+	}
+
+	return info, nil
+}
+
+// infoFromUpload returns the summary of an upload record, without the details of its derivatives
+func infoFromUpload(upload db.Upload) *Info {
+	return &Info{
+		ID:            upload.ID,
+		Kind:          upload.Kind,
+		Owner:         upload.Owner,
+		CreatedDate:   upload.MetaData["createdDate"],
+		Filename:      upload.MetaData["filename"],
+		Size:          upload.Size,
+		Status:        upload.Status,
+		Error:         upload.Error,
+		BlurHash:      upload.MetaData["blurhash"],
+		LQIP:          upload.MetaData["lqip"],
+		DominantColor: upload.MetaData["dominantColor"],
+	}
+}
+
+// loadVideo reads the HLS master playlist of a transcoded video and lists its posters
+func loadVideo(id string, derivatives []db.Derivative) *VideoInfo {
+	video := &VideoInfo{
+		MasterPlaylistURL: config.PublicBaseURL + "/hls/" + id + "/master.m3u8",
+		Renditions:        []Rendition{},
+		PosterURLs:        map[string]string{},
+	}
+
+	renditions, err := parseMasterPlaylist(config.HLSDir + id + "/master.m3u8")
+	if err == nil && len(renditions) > 0 {
+		for i := range renditions {
+			renditions[i].URL = config.PublicBaseURL + "/hls/" + id + "/" + renditions[i].playlist
+			if renditions[i].Height > video.Height {
+				video.Width, video.Height = renditions[i].Width, renditions[i].Height
+			}
+		}
+		slices.SortFunc(renditions, func(a, b Rendition) int { return a.Height - b.Height })
+		video.Renditions = renditions
+
+		// Every rendition covers the whole video, the first one is enough for the duration
+		video.Duration, _ = playlistDuration(path.Join(config.HLSDir, id, renditions[0].playlist))
+	}
+
+	for _, derivative := range derivatives {
+		if derivative.Kind == db.DerivativeThumbnail {
+			video.PosterURLs[derivative.Name] = thumbnailURL(derivative.Path)
+		}
+	}
+
+	return video
+}
+
+// loadImage lists the WebP variants generated for an image
+func loadImage(derivatives []db.Derivative) *ImageInfo {
+	imageInfo := &ImageInfo{Variants: []Variant{}}
+	for _, derivative := range derivatives {
+		if derivative.Kind != db.DerivativeThumbnail {
+			continue
+		}
+		imageInfo.Variants = append(imageInfo.Variants, Variant{
+			Name:   derivative.Name,
+			URL:    thumbnailURL(derivative.Path),
+			Width:  derivative.Width,
+			Height: derivative.Height,
+		})
+	}
+	return imageInfo
+}
+
+// thumbnailURL returns the public URL of a file served from the thumbnail directory
+func thumbnailURL(filePath string) string {
+	return config.PublicBaseURL + "/thumbnail/" + path.Base(filePath)
+}
@@ -0,0 +1,303 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/storage"
+	"github.com/LinuxSploit/TusAce/utils"
+)
+
+// orphanMinAge keeps the janitor away from files that are still being written
+const orphanMinAge = time.Hour
+
+// cleanupMu serializes the runs of Cleanup
+var cleanupMu sync.Mutex
+
+// CleanupReport summarises one janitor run. A dry run reports what a run would remove and reclaim
+// without removing anything.
+type CleanupReport struct {
+	DryRun             bool  `json:"dryRun"`
+	ExpiredUploads     int   `json:"expiredUploads"`
+	PurgedDeleted      int   `json:"purgedDeleted"`
+	OrphanedHLS        int   `json:"orphanedHls"`
+	OrphanedThumbnails int   `json:"orphanedThumbnails"`
+	SupersededHLS      int   `json:"supersededHls"`
+	BytesReclaimed     int64 `json:"bytesReclaimed"`
+	PrunedDeliveries   int   `json:"prunedDeliveries"`
+	// UnknownOutputs lists the ids of the HLS output and thumbnails of uploads the database never
+	// recorded, such as outputs written before it existed. They are left for an operator to import or remove.
+	UnknownOutputs []string `json:"unknownOutputs"`
+}
+
+// UploadExpires returns when an unfinished upload expires, following the tus expiration extension
+func UploadExpires(upload db.Upload) time.Time {
+	return upload.Created.Add(config.IncompleteUploadTTL)
+}
+
+// Cleanup expires unfinished uploads older than config.IncompleteUploadTTL, purges deleted media past
+// its grace period, removes the HLS directories and thumbnails left over by purged uploads and the HLS
+// output replaced by re-transcodes, and prunes the webhook delivery log. With dryRun nothing is removed, the report tells what would be.
+func Cleanup(dryRun bool) CleanupReport {
+	// Runs triggered through the admin API must not overlap with the scheduled ones
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	report := CleanupReport{DryRun: dryRun, UnknownOutputs: []string{}}
+	now := time.Now()
+	deleteCutoff := now.Add(-config.DeleteGracePeriod)
+
+	var expired, purgeable []string
+	err := db.Default.ForEachUpload(func(upload db.Upload) bool {
+		switch {
+		case !upload.Deleted.IsZero() && upload.Deleted.Before(deleteCutoff):
+			purgeable = append(purgeable, upload.ID)
+		case upload.Status == db.StatusUploading && UploadExpires(upload).Before(now):
+			expired = append(expired, upload.ID)
+		}
+		return true
+	})
+	if err != nil {
+		slog.Error("janitor failed to list uploads", "error", err)
+		return report
+	}
+
+	for _, id := range expired {
+		reclaimed, err := purge(id, dryRun)
+		if err != nil {
+			slog.Error("janitor failed to expire upload", "upload_id", id, "error", err)
+			continue
+		}
+		report.ExpiredUploads++
+		report.BytesReclaimed += reclaimed
+	}
+
+	for _, id := range purgeable {
+		reclaimed, err := purge(id, dryRun)
+		if err != nil {
+			slog.Error("janitor failed to purge media", "upload_id", id, "error", err)
+			continue
+		}
+		report.PurgedDeleted++
+		report.BytesReclaimed += reclaimed
+	}
+
+	// HLS output lives in a directory named after the upload id
+	ctx := context.Background()
+	hlsFiles, err := storage.Default.List(ctx, storage.HLS, "")
+	if err != nil {
+		slog.Error("janitor failed to list HLS output", "error", err)
+	}
+	hlsByID := byUpload(hlsFiles, hlsUploadID)
+	hlsOrphans, hlsUnknown := orphans(hlsByID)
+	for id, files := range hlsOrphans {
+		path := filepath.Join(config.HLSDir, id)
+		if !dryRun {
+			if err := os.RemoveAll(path); err != nil {
+				slog.Error("janitor failed to remove orphan", "path", path, "error", err)
+				continue
+			}
+			if err := storage.Default.Remove(ctx, storage.HLS, id+"/"); err != nil {
+				slog.Error("janitor failed to unpublish orphan", "path", path, "error", err)
+				continue
+			}
+		}
+		report.OrphanedHLS++
+		report.BytesReclaimed += filesSize(files)
+	}
+
+	// The output a re-transcode replaced is kept until players are done with it
+	for id, files := range hlsByID {
+		if !db.Default.HasUpload(id) {
+			continue
+		}
+		for name, files := range superseded(id, files) {
+			path := filepath.Join(config.HLSDir, id, name)
+			if !dryRun {
+				if err := os.RemoveAll(path); err != nil {
+					slog.Error("janitor failed to remove superseded HLS output", "path", path, "error", err)
+					continue
+				}
+				prefix := id + "/" + name
+				if len(files) > 1 || files[0].Name != prefix {
+					prefix += "/"
+				}
+				if err := storage.Default.Remove(ctx, storage.HLS, prefix); err != nil {
+					slog.Error("janitor failed to unpublish superseded HLS output", "path", path, "error", err)
+					continue
+				}
+			}
+			report.SupersededHLS++
+			report.BytesReclaimed += filesSize(files)
+		}
+	}
+
+	thumbnailFiles, err := storage.Default.List(ctx, storage.Thumbnail, "")
+	if err != nil {
+		slog.Error("janitor failed to list thumbnails", "error", err)
+	}
+	thumbnailOrphans, thumbnailUnknown := orphans(byUpload(thumbnailFiles, thumbnailUploadID))
+	for _, files := range thumbnailOrphans {
+		for _, file := range files {
+			path := filepath.Join(config.ThumbnailDir, file.Name)
+			if !dryRun {
+				if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					slog.Error("janitor failed to remove orphan", "path", path, "error", err)
+					continue
+				}
+				if err := storage.Default.Remove(ctx, storage.Thumbnail, file.Name); err != nil {
+					slog.Error("janitor failed to unpublish orphan", "path", path, "error", err)
+					continue
+				}
+			}
+			report.OrphanedThumbnails++
+			report.BytesReclaimed += file.Size
+		}
+	}
+
+	report.UnknownOutputs = append(report.UnknownOutputs, hlsUnknown...)
+	for _, id := range thumbnailUnknown {
+		if !slices.Contains(report.UnknownOutputs, id) {
+			report.UnknownOutputs = append(report.UnknownOutputs, id)
+		}
+	}
+	slices.Sort(report.UnknownOutputs)
+	if len(report.UnknownOutputs) > 0 {
+		slog.Warn("janitor found outputs of uploads the database never recorded, they are kept", "count", len(report.UnknownOutputs))
+	}
+
+	// Finished webhook deliveries only stay in the delivery log for config.WebhookLogRetention
+	if !dryRun {
+		pruned, err := db.Default.PruneDeliveries(now.Add(-config.WebhookLogRetention))
+		if err != nil {
+			slog.Error("janitor failed to prune webhook deliveries", "error", err)
+		}
+		report.PrunedDeliveries = pruned
+	}
+
+	slog.Info("janitor finished",
+		"dry_run", report.DryRun,
+		"expired_uploads", report.ExpiredUploads,
+		"purged_deleted", report.PurgedDeleted,
+		"orphaned_hls", report.OrphanedHLS,
+		"orphaned_thumbnails", report.OrphanedThumbnails,
+		"superseded_hls", report.SupersededHLS,
+		"reclaimed_bytes", report.BytesReclaimed,
+		"pruned_deliveries", report.PrunedDeliveries,
+		"unknown_outputs", len(report.UnknownOutputs),
+	)
+	return report
+}
+
+// purge purges an upload with Purge, or only measures what it occupies on disk on a dry run
+func purge(id string, dryRun bool) (int64, error) {
+	if !dryRun {
+		return Purge(id)
+	}
+
+	upload, err := db.Default.GetUpload(id)
+	if err != nil {
+		return 0, err
+	}
+	derivatives, err := db.Default.Derivatives(id)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, path := range Paths(upload, derivatives) {
+		size += utils.DirSize(path)
+	}
+	return size, nil
+}
+
+// byUpload groups published files by the upload they belong to, as told by idOf
+func byUpload(files []storage.File, idOf func(name string) string) map[string][]storage.File {
+	byID := map[string][]storage.File{}
+	for _, file := range files {
+		if id := idOf(file.Name); validID.MatchString(id) {
+			byID[id] = append(byID[id], file)
+		}
+	}
+	return byID
+}
+
+// orphans returns the files of purged uploads that are old enough to not be in progress, by id. The ids of
+// files belonging to no upload the database ever recorded are returned as unknown: they may predate the
+// database and are never removed.
+func orphans(byID map[string][]storage.File) (map[string][]storage.File, []string) {
+	orphaned := map[string][]storage.File{}
+	var unknown []string
+	for id, files := range byID {
+		switch {
+		case db.Default.HasUpload(id):
+		case !db.Default.WasPurged(id):
+			unknown = append(unknown, id)
+		case !slices.ContainsFunc(files, func(file storage.File) bool { return time.Since(file.ModTime) <= orphanMinAge }):
+			orphaned[id] = files
+		}
+	}
+	return orphaned, unknown
+}
+
+// superseded returns the files of the HLS output of an upload that a re-transcode replaced, by the
+// entry of the output directory they are in, once the master playlist was swapped away from them more
+// than config.PlaylistMaxAge and config.SupersededHLSRetention ago. Only a master playlist pointing
+// into a single version directory has superseded entries, any other file or directory next to it is.
+func superseded(id string, files []storage.File) map[string][]storage.File {
+	masterIndex := slices.IndexFunc(files, func(file storage.File) bool { return file.Name == id+"/master.m3u8" })
+	if masterIndex < 0 || time.Since(files[masterIndex].ModTime) <= config.PlaylistMaxAge+config.SupersededHLSRetention {
+		return nil
+	}
+
+	renditions, err := parseMasterPlaylist(config.HLSDir + id + "/master.m3u8")
+	if err != nil || len(renditions) == 0 {
+		return nil
+	}
+	live, _, versioned := strings.Cut(renditions[0].playlist, "/")
+	for _, rendition := range renditions {
+		if version, _, _ := strings.Cut(rendition.playlist, "/"); version != live {
+			versioned = false
+		}
+	}
+	if !versioned {
+		return nil
+	}
+
+	entries := map[string][]storage.File{}
+	for _, file := range files {
+		entry, _, _ := strings.Cut(strings.TrimPrefix(file.Name, id+"/"), "/")
+		if entry != "master.m3u8" && entry != live && !strings.HasPrefix(entry, ".") {
+			entries[entry] = append(entries[entry], file)
+		}
+	}
+	return entries
+}
+
+// filesSize returns the total size of files
+func filesSize(files []storage.File) int64 {
+	var size int64
+	for _, file := range files {
+		size += file.Size
+	}
+	return size
+}
+
+// StartJanitor runs Cleanup every interval in the background
+func StartJanitor(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			Cleanup(false)
+		}
+	}()
+}
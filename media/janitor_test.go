@@ -0,0 +1,230 @@
+package media
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/storage"
+)
+
+// useTempStorage moves the storage directories and the database to a temporary directory, with the
+// local storage backend
+func useTempStorage(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	for dir, value := range map[*string]string{
+		&config.VideosDir:    "videos",
+		&config.ImagesDir:    "images",
+		&config.HLSDir:       "hls",
+		&config.ThumbnailDir: "thumbnail",
+	} {
+		previous := *dir
+		*dir = filepath.Join(root, value) + "/"
+		t.Cleanup(func() { *dir = previous })
+	}
+
+	database, err := db.Open(filepath.Join(root, "media.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousDB, previousBackend := db.Default, storage.Default
+	db.Default = database
+	t.Cleanup(func() {
+		database.Close()
+		db.Default, storage.Default = previousDB, previousBackend
+	})
+
+	backend, err := storage.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Default = backend
+}
+
+// writeOldFile writes a file last modified age ago
+func writeOldFile(t *testing.T, path string, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-age)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCleanupKeepsOutputsPredatingTheDatabase(t *testing.T) {
+	useTempStorage(t)
+
+	// Outputs written before the database existed, no record and never purged
+	writeOldFile(t, config.HLSDir+"legacy/master.m3u8", 48*time.Hour)
+	writeOldFile(t, config.HLSDir+"legacy/720p_000.ts", 48*time.Hour)
+	writeOldFile(t, config.ThumbnailDir+"legacy-500w.webp", 48*time.Hour)
+	writeOldFile(t, config.ThumbnailDir+"poster_only-500w.webp", 48*time.Hour)
+	// A recorded upload
+	writeOldFile(t, config.HLSDir+"live/master.m3u8", 48*time.Hour)
+	writeOldFile(t, config.ThumbnailDir+"live-500w.webp", 48*time.Hour)
+	// Left over by purged uploads, one of them only just written
+	writeOldFile(t, config.HLSDir+"gone/master.m3u8", 48*time.Hour)
+	writeOldFile(t, config.ThumbnailDir+"gone-500w.webp", 48*time.Hour)
+	writeOldFile(t, config.ThumbnailDir+"gone-500x500.webp", 48*time.Hour)
+	writeOldFile(t, config.HLSDir+"late/master.m3u8", 0)
+
+	for _, id := range []string{"live", "gone", "late"} {
+		if err := db.Default.PutUpload(db.Upload{ID: id, Kind: db.KindVideo, Status: db.StatusReady, Created: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"gone", "late"} {
+		if err := db.Default.DeleteUpload(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kept := []string{
+		config.HLSDir + "legacy/master.m3u8", config.HLSDir + "legacy/720p_000.ts", config.ThumbnailDir + "legacy-500w.webp",
+		config.ThumbnailDir + "poster_only-500w.webp",
+		config.HLSDir + "live/master.m3u8", config.ThumbnailDir + "live-500w.webp",
+		config.HLSDir + "late/master.m3u8",
+	}
+	orphans := []string{config.HLSDir + "gone", config.ThumbnailDir + "gone-500w.webp", config.ThumbnailDir + "gone-500x500.webp"}
+
+	tests := []struct {
+		dryRun      bool
+		orphansKept bool
+	}{
+		{dryRun: true, orphansKept: true},
+		{dryRun: false, orphansKept: false},
+	}
+	for _, test := range tests {
+		report := Cleanup(test.dryRun)
+
+		if report.DryRun != test.dryRun {
+			t.Errorf("dry run %v: report.DryRun = %v", test.dryRun, report.DryRun)
+		}
+		if report.OrphanedHLS != 1 || report.OrphanedThumbnails != 2 {
+			t.Errorf("dry run %v: %d orphaned HLS and %d thumbnails, want 1 and 2", test.dryRun, report.OrphanedHLS, report.OrphanedThumbnails)
+		}
+		if report.BytesReclaimed != 3*int64(len("data")) {
+			t.Errorf("dry run %v: %d bytes reclaimed, want %d", test.dryRun, report.BytesReclaimed, 3*len("data"))
+		}
+		if want := []string{"legacy", "poster_only"}; !slices.Equal(report.UnknownOutputs, want) {
+			t.Errorf("dry run %v: unknown outputs %v, want %v", test.dryRun, report.UnknownOutputs, want)
+		}
+
+		for _, path := range kept {
+			if !exists(path) {
+				t.Errorf("dry run %v: %s was removed", test.dryRun, path)
+			}
+		}
+		for _, path := range orphans {
+			if exists(path) != test.orphansKept {
+				t.Errorf("dry run %v: %s exists = %v, want %v", test.dryRun, path, !test.orphansKept, test.orphansKept)
+			}
+		}
+	}
+}
+
+func TestCleanupDryRunPurgesNothing(t *testing.T) {
+	useTempStorage(t)
+
+	deleted := time.Now().Add(-config.DeleteGracePeriod - time.Hour)
+	if err := db.Default.PutUpload(db.Upload{ID: "deleted", Kind: db.KindImage, Status: db.StatusReady, Created: deleted, Deleted: deleted}); err != nil {
+		t.Fatal(err)
+	}
+	writeOldFile(t, config.ImagesDir+"deleted", 0)
+
+	report := Cleanup(true)
+	if report.PurgedDeleted != 1 || report.BytesReclaimed != int64(len("data")) {
+		t.Errorf("dry run purged %d uploads and reclaimed %d bytes, want 1 and %d", report.PurgedDeleted, report.BytesReclaimed, len("data"))
+	}
+	if !db.Default.HasUpload("deleted") || !exists(config.ImagesDir+"deleted") {
+		t.Error("dry run removed the deleted upload")
+	}
+
+	report = Cleanup(false)
+	if report.PurgedDeleted != 1 || db.Default.HasUpload("deleted") || exists(config.ImagesDir+"deleted") {
+		t.Errorf("purged %d uploads, the upload is still there", report.PurgedDeleted)
+	}
+	if !db.Default.WasPurged("deleted") {
+		t.Error("the purged upload is not recorded as purged")
+	}
+}
+
+func TestCleanupRemovesSupersededHLSAfterRetention(t *testing.T) {
+	useTempStorage(t)
+
+	// Transcoded, then re-transcoded twice: v2 is live
+	for _, name := range []string{"720p.m3u8", "720p_000.ts", "thumbnail.jpg", "v1/720p.m3u8", "v1/720p_000.ts", "v2/720p.m3u8", "v2/720p_000.ts"} {
+		writeOldFile(t, config.HLSDir+"video/"+name, 48*time.Hour)
+	}
+	// Never re-transcoded
+	for _, name := range []string{"720p.m3u8", "720p_000.ts", "thumbnail.jpg"} {
+		writeOldFile(t, config.HLSDir+"single/"+name, 48*time.Hour)
+	}
+	writeMaster := func(id, playlist string, age time.Duration) {
+		t.Helper()
+		path := config.HLSDir + id + "/master.m3u8"
+		writeOldFile(t, path, age)
+		if err := os.WriteFile(path, []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1,RESOLUTION=1280x720\n"+playlist+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Now().Add(-age)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeMaster("single", "720p.m3u8", 48*time.Hour)
+	for _, id := range []string{"video", "single"} {
+		if err := db.Default.PutUpload(db.Upload{ID: id, Kind: db.KindVideo, Status: db.StatusReady, Created: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	live := []string{"video/master.m3u8", "video/v2/720p.m3u8", "video/v2/720p_000.ts", "single/master.m3u8", "single/720p.m3u8", "single/720p_000.ts", "single/thumbnail.jpg"}
+	replaced := []string{"video/720p.m3u8", "video/720p_000.ts", "video/thumbnail.jpg", "video/v1"}
+
+	tests := []struct {
+		name       string
+		swappedAgo time.Duration
+		removed    bool
+	}{
+		{"just swapped", time.Minute, false},
+		{"within retention", config.SupersededHLSRetention - time.Minute, false},
+		{"past retention", config.PlaylistMaxAge + config.SupersededHLSRetention + time.Minute, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeMaster("video", "v2/720p.m3u8", test.swappedAgo)
+
+			report := Cleanup(false)
+			if want := map[bool]int{false: 0, true: len(replaced)}[test.removed]; report.SupersededHLS != want {
+				t.Errorf("%d superseded entries removed, want %d", report.SupersededHLS, want)
+			}
+			for _, name := range live {
+				if !exists(config.HLSDir + name) {
+					t.Errorf("live %s was removed", name)
+				}
+			}
+			for _, name := range replaced {
+				if exists(config.HLSDir+name) == test.removed {
+					t.Errorf("superseded %s exists = %v, want %v", name, test.removed, !test.removed)
+				}
+			}
+		})
+	}
+}
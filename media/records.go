@@ -0,0 +1,120 @@
+package media
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/storage"
+	"github.com/LinuxSploit/TusAce/utils"
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// UploadRecord creates the database record of a tusd upload
+func UploadRecord(kind string, upload handler.FileInfo) db.Upload {
+	created, err := time.Parse(time.RFC3339, upload.MetaData["createdDate"])
+	if err != nil {
+		created = time.Now().UTC()
+	}
+
+	status := db.StatusUploading
+	if !upload.SizeIsDeferred && upload.Offset == upload.Size {
+		status = db.StatusProcessing
+	}
+
+	return db.Upload{
+		ID:       upload.ID,
+		Kind:     kind,
+		Owner:    upload.MetaData["owner"],
+		Created:  created,
+		Size:     upload.Size,
+		Status:   status,
+		MetaData: upload.MetaData,
+	}
+}
+
+// ImportUploads records the uploads found in the storage backend that are not in the database yet,
+// such as uploads made before the database existed. Their status is derived from the published outputs.
+func ImportUploads(ctx context.Context) error {
+	imported := 0
+	for _, kind := range []string{db.KindVideo, db.KindImage} {
+		uploads, err := storage.Default.Uploads(ctx, kind)
+		if err != nil {
+			return err
+		}
+
+		for _, upload := range uploads {
+			if db.Default.HasUpload(upload.ID) {
+				continue
+			}
+			if err := importUpload(ctx, kind, upload); err != nil {
+				slog.Error("failed to import upload", "upload_id", upload.ID, "error", err)
+				continue
+			}
+			imported++
+		}
+	}
+
+	if imported > 0 {
+		slog.Info("imported uploads into the database", "count", imported)
+	}
+	return nil
+}
+
+// importUpload records a single upload along with its published derivatives
+func importUpload(ctx context.Context, kind string, upload handler.FileInfo) error {
+	record := UploadRecord(kind, upload)
+
+	published, err := storage.Default.List(ctx, storage.Thumbnail, upload.ID+"-")
+	if err != nil {
+		return err
+	}
+	var thumbnails []db.Derivative
+	for _, variant := range utils.ImageVariants {
+		thumbnailPath := utils.VariantPath(config.ThumbnailDir, upload.ID, variant)
+		index := slices.IndexFunc(published, func(file storage.File) bool { return file.Name == filepath.Base(thumbnailPath) })
+		if index < 0 {
+			continue
+		}
+		derivative := db.Derivative{Kind: db.DerivativeThumbnail, Name: variant.Name, Path: thumbnailPath, Size: published[index].Size}
+		// The dimensions are read from the local copy the variant was generated as, when it is still there
+		derivative.Width, derivative.Height, _ = utils.WebPDimensions(thumbnailPath)
+		thumbnails = append(thumbnails, derivative)
+	}
+
+	hls, err := storage.Default.List(ctx, storage.HLS, upload.ID+"/")
+	if err != nil {
+		return err
+	}
+	hasMaster := slices.ContainsFunc(hls, func(file storage.File) bool { return file.Name == upload.ID+"/master.m3u8" })
+	var hlsSize int64
+	for _, file := range hls {
+		hlsSize += file.Size
+	}
+
+	switch {
+	case record.Status == db.StatusUploading:
+	case kind == db.KindVideo && hasMaster:
+		record.Status = db.StatusReady
+	case kind == db.KindImage && len(thumbnails) > 0:
+		record.Status = db.StatusReady
+	case kind == db.KindImage:
+		// Variants are generated while the final PATCH is answered, so a finished image without them failed
+		record.Status = db.StatusFailed
+	}
+
+	if err := db.Default.PutUpload(record); err != nil {
+		return err
+	}
+	if err := db.Default.PutDerivatives(upload.ID, db.DerivativeThumbnail, thumbnails); err != nil {
+		return err
+	}
+	if kind == db.KindVideo && hasMaster {
+		return db.Default.PutDerivatives(upload.ID, db.DerivativeHLS, []db.Derivative{{Kind: db.DerivativeHLS, Name: "master", Path: config.HLSDir + upload.ID, Size: hlsSize}})
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package media
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/storage"
+)
+
+// hlsUploadID returns the id of the upload an HLS file belongs to, the output lives in a directory
+// named after the upload id
+func hlsUploadID(name string) string {
+	id, _, _ := strings.Cut(name, "/")
+	return id
+}
+
+// thumbnailUploadID returns the id of the upload a thumbnail belongs to. Thumbnails are named
+// <id>-<variant>.webp, the variant names hold no dash while s3store ids may.
+func thumbnailUploadID(name string) string {
+	if end := strings.LastIndex(name, "-"); end >= 0 {
+		return name[:end]
+	}
+	return ""
+}
+
+// HideDeleted answers 404 for the published files of deleted uploads in area, which stay on disk until
+// the upload is restored or purged. Files of uploads the database does not know, older than it, are
+// served as before.
+func HideDeleted(area storage.Area, next http.Handler) http.Handler {
+	idOf := hlsUploadID
+	if area == storage.Thumbnail {
+		idOf = thumbnailUploadID
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if upload, err := db.Default.GetUpload(idOf(name)); err == nil && !upload.Deleted.IsZero() {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
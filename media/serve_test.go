@@ -0,0 +1,72 @@
+package media
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/storage"
+)
+
+func TestHideDeleted(t *testing.T) {
+	useTempStorage(t)
+	for _, upload := range []db.Upload{
+		{ID: "live", Kind: db.KindVideo, Status: db.StatusReady, Created: time.Now()},
+		{ID: "gone", Kind: db.KindVideo, Status: db.StatusReady, Created: time.Now(), Deleted: time.Now()},
+		{ID: "obj-1+mp", Kind: db.KindImage, Status: db.StatusReady, Created: time.Now(), Deleted: time.Now()},
+	} {
+		if err := db.Default.PutUpload(upload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"live/master.m3u8", "gone/master.m3u8", "legacy/master.m3u8"} {
+		writeOldFile(t, filepath.Join(config.HLSDir, name), 0)
+	}
+	for _, name := range []string{"live-500w.webp", "gone-500w.webp", "obj-1+mp-500w.webp"} {
+		writeOldFile(t, filepath.Join(config.ThumbnailDir, name), 0)
+	}
+
+	servers := map[storage.Area]http.Handler{}
+	for _, area := range []storage.Area{storage.HLS, storage.Thumbnail} {
+		servers[area] = HideDeleted(area, storage.FileServer(storage.Default.FileSystem(area)))
+	}
+
+	tests := []struct {
+		name       string
+		area       storage.Area
+		path       string
+		wantStatus int
+	}{
+		{"playlist of a live upload", storage.HLS, "/live/master.m3u8", http.StatusOK},
+		{"playlist of a deleted upload", storage.HLS, "/gone/master.m3u8", http.StatusNotFound},
+		{"playlist of a deleted upload, unclean path", storage.HLS, "/./x/../gone/master.m3u8", http.StatusNotFound},
+		{"playlist older than the database", storage.HLS, "/legacy/master.m3u8", http.StatusOK},
+		{"thumbnail of a live upload", storage.Thumbnail, "/live-500w.webp", http.StatusOK},
+		{"thumbnail of a deleted upload", storage.Thumbnail, "/gone-500w.webp", http.StatusNotFound},
+		{"thumbnail of a deleted upload with a dash in its id", storage.Thumbnail, "/obj-1+mp-500w.webp", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			servers[test.area].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if rec.Code != test.wantStatus {
+				t.Errorf("GET %s answered %d, want %d", test.path, rec.Code, test.wantStatus)
+			}
+		})
+	}
+
+	// Restoring the upload publishes its files again
+	if err := Restore("gone"); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	servers[storage.HLS].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gone/master.m3u8", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET of the restored upload answered %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// diskUsageTTL is how long the walked directory sizes are reused, walking the storage is expensive
+const diskUsageTTL = time.Minute
+
+func init() {
+	prometheus.MustRegister(uploadCollector{}, &diskUsageCollector{})
+}
+
+var (
+	uploadsDesc = prometheus.NewDesc(namespace+"_uploads", "Recorded uploads by kind and status.", []string{"kind", "status"}, nil)
+	bytesDesc   = prometheus.NewDesc(namespace+"_upload_bytes", "Size of the recorded uploads by kind and status.", []string{"kind", "status"}, nil)
+	diskDesc    = prometheus.NewDesc(namespace+"_storage_bytes", "Disk usage of each storage directory.", []string{"dir"}, nil)
+)
+
+// uploadCollector counts the uploads recorded in the database at scrape time
+type uploadCollector struct{}
+
+func (uploadCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- uploadsDesc
+	ch <- bytesDesc
+}
+
+func (uploadCollector) Collect(ch chan<- prometheus.Metric) {
+	if db.Default == nil {
+		return
+	}
+
+	type key struct{ kind, status string }
+	counts, sizes := map[key]int{}, map[key]int64{}
+	err := db.Default.ForEachUpload(func(upload db.Upload) bool {
+		status := upload.Status
+		if !upload.Deleted.IsZero() {
+			status = "deleted"
+		}
+		k := key{upload.Kind, status}
+		counts[k]++
+		sizes[k] += upload.Size
+		return true
+	})
+	if err != nil {
+		slog.Error("failed to collect upload metrics", "error", err)
+		return
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(uploadsDesc, prometheus.GaugeValue, float64(count), k.kind, k.status)
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(sizes[k]), k.kind, k.status)
+	}
+}
+
+// diskUsageCollector reports the size of the storage directories, walked at most once per diskUsageTTL
+type diskUsageCollector struct {
+	mu      sync.Mutex
+	updated time.Time
+	sizes   map[string]int64
+}
+
+func (c *diskUsageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- diskDesc
+}
+
+func (c *diskUsageCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.updated) > diskUsageTTL {
+		c.sizes = map[string]int64{
+			"videos":    utils.DirSize(config.VideosDir),
+			"images":    utils.DirSize(config.ImagesDir),
+			"hls":       utils.DirSize(config.HLSDir),
+			"thumbnail": utils.DirSize(config.ThumbnailDir),
+			"staging":   utils.DirSize(config.StagingDir),
+			"archive":   utils.DirSize(config.ArchiveDir),
+		}
+		c.updated = time.Now()
+	}
+
+	for dir, size := range c.sizes {
+		ch <- prometheus.MustNewConstMetric(diskDesc, prometheus.GaugeValue, float64(size), dir)
+	}
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric of the service
+const namespace = "tusace"
+
+// Upload metrics, fed from the tusd handler events and requests
+var (
+	UploadsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_created_total",
+		Help:      "Uploads created through the tus endpoints.",
+	}, []string{"kind"})
+
+	UploadsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_completed_total",
+		Help:      "Uploads whose data was fully received.",
+	}, []string{"kind"})
+
+	UploadBytesCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_completed_total",
+		Help:      "Bytes of the completed uploads.",
+	}, []string{"kind"})
+
+	ActiveUploads = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "uploads_active",
+		Help:      "tus PATCH requests currently receiving data.",
+	}, []string{"kind"})
+
+	PatchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tus_patch_duration_seconds",
+		Help:      "Duration of tus PATCH requests.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"kind", "code"})
+)
+
+// Session validation against the auth service
+var (
+	AuthValidationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "auth_validation_duration_seconds",
+		Help:      "Duration of session validations against the auth service.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	AuthValidationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_validation_failures_total",
+		Help:      "Session validations that did not succeed, rejected by the auth service or failed with an error.",
+	}, []string{"reason"})
+)
+
+// Processing metrics, fed from the transcode worker and the image hook
+var (
+	TranscodeJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transcode_jobs_total",
+		Help:      "Finished transcode job runs by type and outcome.",
+	}, []string{"type", "outcome"})
+
+	// All renditions of the ladder are encoded by a single ffmpeg run, so they share the duration
+	// and realtime factor observed for it
+	TranscodeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transcode_duration_seconds",
+		Help:      "Wall time of successful encodes, per rendition.",
+		Buckets:   prometheus.ExponentialBuckets(5, 2, 12),
+	}, []string{"rendition"})
+
+	TranscodeRealtimeFactor = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transcode_realtime_factor",
+		Help:      "Seconds of video encoded per second of wall time, per rendition.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"rendition"})
+
+	ImageProcessingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_processing_duration_seconds",
+		Help:      "Time to decode an uploaded image and generate its variants.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+)
+
+// Upload event bus, see package events
+var (
+	EventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dropped_total",
+		Help:      "Progress events dropped because a subscriber fell behind.",
+	}, []string{"subscriber"})
+
+	EventSubscriberPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_subscriber_panics_total",
+		Help:      "Events whose subscriber panicked while handling them.",
+	}, []string{"subscriber"})
+)
+
+// RegisterQueueDepth exposes the number of ids waiting in a queue
+func RegisterQueueDepth(queue string, depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "queue_depth",
+		Help:        "Jobs waiting in a transcode queue.",
+		ConstLabels: prometheus.Labels{"queue": queue},
+	}, func() float64 { return float64(depth()) })
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// principalKey is the context key holding the email of the authenticated uploader
+type principalKey struct{}
+
+// RequireSession validates the Authorization and x-email-address headers the same way the tus
+// hooks do, and makes the validated email available to next through Principal
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionToken := r.Header.Get("Authorization")
+		email := r.Header.Get("x-email-address")
+
+		if sessionToken == "" || email == "" || ValidateSessionAndPerm(sessionToken, email) == 0 {
+			http.Error(w, "Invalid or missing session token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, email)))
+	})
+}
+
+// Principal returns the email validated by RequireSession, or an empty string outside of it
+func Principal(ctx context.Context) string {
+	email, _ := ctx.Value(principalKey{}).(string)
+	return email
+}
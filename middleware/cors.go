@@ -1,23 +1,153 @@
 package middleware
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-// CORSMiddleware handles CORS headers
-func CORSMiddleware(next http.Handler) http.Handler {
+	"github.com/LinuxSploit/TusAce/config"
+)
+
+// corsMaxAge is how long browsers may cache the answer to a preflight request
+const corsMaxAge = 24 * time.Hour
+
+// Headers sent and read by the clients, the header lists of every policy are built from these
+var (
+	// sessionHeaders authenticate the uploads and the media API, see RequireSession
+	sessionHeaders = []string{"Authorization", "X-Email-Address"}
+	// requestHeaders may come with any request, X-Request-ID is picked up by RequestID
+	requestHeaders = []string{"Content-Type", "X-Request-ID"}
+	// responseHeaders come with every response
+	responseHeaders = []string{"X-Request-ID"}
+	// tusRequestHeaders are sent by tus clients, including those of the IETF resumable upload draft
+	tusRequestHeaders = []string{
+		"Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Defer-Length", "Upload-Concat",
+		"Upload-Incomplete", "Upload-Complete", "Upload-Draft-Interop-Version", "Tus-Resumable",
+		"X-HTTP-Method-Override", "X-Requested-With",
+	}
+	// tusResponseHeaders are read by tus clients, Upload-Expires is set by tus.ExpirationMiddleware
+	tusResponseHeaders = []string{
+		"Location", "Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Defer-Length", "Upload-Concat",
+		"Upload-Incomplete", "Upload-Complete", "Upload-Draft-Interop-Version", "Upload-Expires",
+		"Tus-Version", "Tus-Resumable", "Tus-Max-Size", "Tus-Extension",
+	}
+	// rangeRequestHeaders and rangeResponseHeaders let players fetch parts of a file
+	rangeRequestHeaders  = []string{"Range"}
+	rangeResponseHeaders = []string{"Content-Length", "Content-Range", "ETag"}
+)
+
+// CORSPolicy is the cross-origin policy of a group of routes
+type CORSPolicy struct {
+	// Origins are the allowed origins, such as https://app.example.com. https://*.example.com allows
+	// the subdomains of example.com and * any origin. The matched origin is sent back to the client.
+	Origins       []string
+	Methods       []string
+	AllowHeaders  []string
+	ExposeHeaders []string
+	// Credentials lets browsers send cookies and HTTP authentication with cross-origin requests
+	Credentials bool
+}
+
+// Policies of the route groups
+var (
+	// UploadCORS is the policy of both tus handlers
+	UploadCORS = CORSPolicy{
+		Origins:       config.CORSUploadOrigins,
+		Methods:       []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+		AllowHeaders:  slices.Concat(sessionHeaders, requestHeaders, tusRequestHeaders),
+		ExposeHeaders: slices.Concat(responseHeaders, tusResponseHeaders),
+		Credentials:   config.CORSAllowCredentials,
+	}
+
+	// PlaybackCORS is the policy of the HLS and thumbnail file servers, which are read-only and public
+	PlaybackCORS = CORSPolicy{
+		Origins:       config.CORSPlaybackOrigins,
+		Methods:       []string{http.MethodGet, http.MethodHead, http.MethodOptions},
+		AllowHeaders:  rangeRequestHeaders,
+		ExposeHeaders: slices.Concat(responseHeaders, rangeResponseHeaders),
+	}
+
+	// APICORS is the policy of the media API
+	APICORS = CORSPolicy{
+		Origins:       config.CORSAPIOrigins,
+		Methods:       []string{http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodOptions},
+		AllowHeaders:  slices.Concat(sessionHeaders, requestHeaders),
+		ExposeHeaders: responseHeaders,
+		Credentials:   config.CORSAllowCredentials,
+	}
+)
+
+// Handler applies the policy to the requests of next and answers the preflight requests itself.
+// Requests from origins that are not allowed get no CORS headers, their preflights are refused.
+func (p CORSPolicy) Handler(next http.Handler) http.Handler {
+	origins := p.OriginPattern()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers to allow cross-origin requests
-		w.Header().Set("Access-Control-Allow-Origin", "*") // Adjust the origin if you want to restrict to a specific domain
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS, POST, PATCH, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Range, Content-Type") // Include 'session' and 'email'
-		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
-
-		// Handle preflight requests
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && origins.MatchString(origin)
+		if allowed {
+			header.Set("Access-Control-Allow-Origin", origin)
+			if p.Credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			header.Set("Access-Control-Allow-Methods", p.AllowMethodsHeader())
+			header.Set("Access-Control-Allow-Headers", p.AllowHeadersHeader())
+			header.Set("Access-Control-Max-Age", p.MaxAgeHeader())
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		// Pass the request to the next handler
+		if allowed {
+			header.Set("Access-Control-Expose-Headers", p.ExposeHeadersHeader())
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// OriginPattern returns a case-insensitive pattern matching exactly the allowed origins
+func (p CORSPolicy) OriginPattern() *regexp.Regexp {
+	alternatives := make([]string, 0, len(p.Origins))
+	for _, origin := range p.Origins {
+		if origin == "*" {
+			alternatives = append(alternatives, ".*")
+			continue
+		}
+		quoted := regexp.QuoteMeta(strings.TrimSuffix(origin, "/"))
+		alternatives = append(alternatives, strings.ReplaceAll(quoted, `\*`, `[a-z0-9-]+(?:\.[a-z0-9-]+)*`))
+	}
+	return regexp.MustCompile(`(?i)^(?:` + strings.Join(alternatives, "|") + `)$`)
+}
+
+// Validate rejects a policy that would send credentials to any origin: the origin of every request
+// is reflected, so a page on any site could make authenticated requests on behalf of the user
+func (p CORSPolicy) Validate() error {
+	if p.Credentials && p.AllowsAnyOrigin() {
+		return errors.New("credentials cannot be allowed for any origin, list the allowed origins instead of *")
+	}
+	return nil
+}
+
+// AllowsAnyOrigin reports whether the policy allows every origin
+func (p CORSPolicy) AllowsAnyOrigin() bool {
+	return slices.Contains(p.Origins, "*")
+}
+
+// AllowMethodsHeader, AllowHeadersHeader, ExposeHeadersHeader and MaxAgeHeader return the values of
+// the corresponding Access-Control headers
+func (p CORSPolicy) AllowMethodsHeader() string  { return strings.Join(p.Methods, ", ") }
+func (p CORSPolicy) AllowHeadersHeader() string  { return strings.Join(p.AllowHeaders, ", ") }
+func (p CORSPolicy) ExposeHeadersHeader() string { return strings.Join(p.ExposeHeaders, ", ") }
+func (p CORSPolicy) MaxAgeHeader() string        { return strconv.Itoa(int(corsMaxAge.Seconds())) }
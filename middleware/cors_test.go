@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{"exact origin", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"case-insensitive", []string{"https://app.example.com"}, "HTTPS://App.Example.com", true},
+		{"trailing slash in the policy", []string{"https://app.example.com/"}, "https://app.example.com", true},
+		{"other scheme", []string{"https://app.example.com"}, "http://app.example.com", false},
+		{"other port", []string{"https://app.example.com"}, "https://app.example.com:8443", false},
+		{"with port", []string{"http://localhost:3000"}, "http://localhost:3000", true},
+		{"prefix of the origin", []string{"https://app.example.com"}, "https://app.example.com.evil.net", false},
+		{"suffix of the origin", []string{"https://app.example.com"}, "https://evilapp.example.com", false},
+		{"dot is literal", []string{"https://app.example.com"}, "https://appxexample.com", false},
+		{"second origin", []string{"https://a.example.com", "https://b.example.com"}, "https://b.example.com", true},
+		{"wildcard subdomain", []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"wildcard nested subdomain", []string{"https://*.example.com"}, "https://eu.cdn.example.com", true},
+		{"wildcard needs a subdomain", []string{"https://*.example.com"}, "https://example.com", false},
+		{"wildcard other domain", []string{"https://*.example.com"}, "https://example.com.evil.net", false},
+		{"wildcard lookalike domain", []string{"https://*.example.com"}, "https://app.evilexample.com", false},
+		{"wildcard does not match a path", []string{"https://*.example.com"}, "https://evil.net/.example.com", false},
+		{"wildcard does not match credentials", []string{"https://*.example.com"}, "https://evil.net@app.example.com", false},
+		{"wildcard keeps the scheme", []string{"https://*.example.com"}, "http://app.example.com", false},
+		{"any origin", []string{"*"}, "https://anything.test", true},
+		{"no origins", nil, "https://app.example.com", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := CORSPolicy{Origins: test.origins}
+			if got := policy.OriginPattern().MatchString(test.origin); got != test.want {
+				t.Errorf("%v allows %s = %v, want %v", test.origins, test.origin, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	policy := CORSPolicy{
+		Origins:       []string{"https://*.example.com"},
+		Methods:       []string{http.MethodGet, http.MethodOptions},
+		AllowHeaders:  []string{"Authorization"},
+		ExposeHeaders: []string{"X-Request-ID"},
+		Credentials:   true,
+	}
+	handler := policy.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		preflight  bool
+		wantStatus int
+		wantOrigin string
+	}{
+		{"allowed request", http.MethodGet, "https://app.example.com", false, http.StatusOK, "https://app.example.com"},
+		{"refused request", http.MethodGet, "https://evil.net", false, http.StatusOK, ""},
+		{"same-origin request", http.MethodGet, "", false, http.StatusOK, ""},
+		{"allowed preflight", http.MethodOptions, "https://app.example.com", true, http.StatusNoContent, "https://app.example.com"},
+		{"refused preflight", http.MethodOptions, "https://evil.net", true, http.StatusForbidden, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/", nil)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, test.wantStatus)
+			}
+			header := rec.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != test.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, test.wantOrigin)
+			}
+			if got, want := header.Get("Access-Control-Allow-Credentials") == "true", test.wantOrigin != ""; got != want {
+				t.Errorf("credentials allowed = %v, want %v", got, want)
+			}
+			if header.Get("Vary") != "Origin" {
+				t.Errorf("Vary = %q, want Origin", header.Get("Vary"))
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/logging"
+)
+
+// maxRequestIDLength matches the limit tusd applies to X-Request-ID in its own logs
+const maxRequestIDLength = 36
+
+// RequestID gives every request an id, taken from X-Request-ID or generated, returns it in the response
+// and logs the request with it. Handlers get a logger carrying the id through logging.FromContext.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		// tusd reads the header for its own request logs
+		r.Header.Set("X-Request-ID", id)
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := logging.WithRequestID(r.Context(), id)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		logging.FromContext(ctx).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// newRequestID returns 16 random bytes in hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.status = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap gives http.ResponseController, used by tusd and the file servers, access to the original writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -2,11 +2,15 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
+
+	"github.com/LinuxSploit/TusAce/metrics"
 )
 
 type ValidateData struct {
@@ -19,6 +23,9 @@ type ValidateResponse struct {
 	UserType string `json:"userType"`
 }
 
+// validateURL is the endpoint of the auth service validating uploader sessions
+const validateURL = "https://api.mindlinkstechnology.com/api/AceBeauty/isValidUploader"
+
 // ValidateSessionAndPerm checks if the session is valid and returns the user's permission level
 // Return values: 0 = Invalid session, 1 = Regular user, 2 = Influencer
 func ValidateSessionAndPerm(sessionToken, email string) int {
@@ -28,6 +35,16 @@ func ValidateSessionAndPerm(sessionToken, email string) int {
 	const influencerSessionCode = 2
 	const invalidSessionCode = 0
 
+	// Measure the validation, the outcome is "error" unless the auth service answered
+	start := time.Now()
+	outcome := "error"
+	defer func() {
+		metrics.AuthValidationDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+		if outcome != "valid" {
+			metrics.AuthValidationFailures.WithLabelValues(outcome).Inc()
+		}
+	}()
+
 	// Prepare request payload
 	body := ValidateData{
 		Email:        email,
@@ -37,14 +54,14 @@ func ValidateSessionAndPerm(sessionToken, email string) int {
 	// Encode request body to JSON
 	bodyWriter := bytes.NewBuffer([]byte{})
 	if err := json.NewEncoder(bodyWriter).Encode(body); err != nil {
-		log.Printf("Error encoding request body: %v", err)
+		slog.Error("failed to encode session validation request", "error", err)
 		return invalidSessionCode
 	}
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("POST", "https://api.mindlinkstechnology.com/api/AceBeauty/isValidUploader", bodyWriter)
+	req, err := http.NewRequest("POST", validateURL, bodyWriter)
 	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
+		slog.Error("failed to create session validation request", "error", err)
 		return invalidSessionCode
 	}
 	req.Header.Add("Content-Type", "application/json")
@@ -55,36 +72,38 @@ func ValidateSessionAndPerm(sessionToken, email string) int {
 	// Send the request
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Error making HTTP request: %v", err)
+		slog.Error("session validation request failed", "error", err)
 		return invalidSessionCode
 	}
 	defer resp.Body.Close() // Ensure the response body is closed
 
 	// Check for non-200 status codes
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Received non-200 response: %v", resp.Status)
+		slog.Error("session validation returned an unexpected status", "status", resp.StatusCode)
 		return invalidSessionCode
 	}
 
 	// Read and decode the response body
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
+		slog.Error("failed to read session validation response", "error", err)
 		return invalidSessionCode
 	}
 
 	// Unmarshal JSON response
 	var respData ValidateResponse
 	if err := json.Unmarshal(data, &respData); err != nil {
-		log.Printf("Error unmarshalling response: %v", err)
+		slog.Error("failed to decode session validation response", "error", err)
 		return invalidSessionCode
 	}
 
 	// Validate session response
 	if !respData.IsValid {
-		log.Println("Invalid session")
+		slog.Info("session rejected by the auth service")
+		outcome = "rejected"
 		return invalidSessionCode
 	}
+	outcome = "valid"
 
 	// Check the user type
 	if respData.UserType == influencerType {
@@ -93,3 +112,23 @@ func ValidateSessionAndPerm(sessionToken, email string) int {
 
 	return validSessionCode
 }
+
+// CheckAuthBackend reports whether the auth service answers. Any response other than a server error
+// counts, the probe carries no session so it is expected to be refused.
+func CheckAuthBackend(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, validateURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("auth service answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/middleware"
+)
+
+// keepAliveInterval is the time between two comments sent on an idle stream, so that proxies keep it open
+const keepAliveInterval = 15 * time.Second
+
+// StreamHandler serves GET /media/events, a server-sent events stream of the progress of the
+// authenticated principal's uploads. Every message is a "progress" event carrying an Update as JSON,
+// the uploads still in progress are sent first. The session headers are required, so browsers
+// connect with fetch rather than EventSource.
+func StreamHandler(w http.ResponseWriter, r *http.Request) {
+	owner := middleware.Principal(r.Context())
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Keep reverse proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ch, current := subscribe(owner)
+	defer unsubscribe(owner, ch)
+
+	for _, update := range current {
+		if err := writeUpdate(w, update); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logging.FromContext(r.Context()).Error("progress stream cannot be flushed", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case update := <-ch:
+			if err := writeUpdate(w, update); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeUpdate writes an update as a progress event
+func writeUpdate(w http.ResponseWriter, update Update) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+	return err
+}
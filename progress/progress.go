@@ -0,0 +1,151 @@
+package progress
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/LinuxSploit/TusAce/db"
+)
+
+// Stages of an upload, from the first byte to ready to play
+const (
+	StageUploading  = "uploading"
+	StageProcessing = "processing"
+	StageThumbnail  = "thumbnail"
+	StageReady      = "ready"
+	StageFailed     = "failed"
+)
+
+// uploadShare is the part of the overall progress the upload itself accounts for, by kind.
+// Videos spend about as long transcoding as uploading, images are processed in a moment.
+var uploadShare = map[string]float64{
+	db.KindVideo: 50,
+	db.KindImage: 90,
+}
+
+// clientBuffer is how many updates a slow client may have pending before further ones are dropped
+const clientBuffer = 64
+
+// Update is one progress notification about an upload
+type Update struct {
+	UploadID string `json:"uploadId"`
+	Kind     string `json:"kind"`
+	Stage    string `json:"stage"`
+	// Percent is the progress within the stage, Overall the progress from upload through ready to play
+	Percent float64 `json:"percent"`
+	Overall float64 `json:"overall"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// inProgress reports whether the upload is still uploading or processing after the update
+func (u Update) inProgress() bool {
+	return u.Stage == StageUploading || u.Stage == StageProcessing
+}
+
+var (
+	mu sync.Mutex
+	// clients are the channels of the connected streams, by owner
+	clients = map[string]map[chan Update]bool{}
+	// latest holds the last update of every upload still in progress, by owner and upload id,
+	// so that a client connecting late starts from the current state
+	latest = map[string]map[string]Update{}
+)
+
+// Publish sends an update to the streams of the owner of the upload. A stream that does not keep up
+// misses updates rather than delaying the caller.
+func Publish(owner string, update Update) {
+	if owner == "" {
+		return
+	}
+	update.Overall = overall(update)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if update.inProgress() {
+		if latest[owner] == nil {
+			latest[owner] = map[string]Update{}
+		}
+		latest[owner][update.UploadID] = update
+	} else {
+		forget(owner, update.UploadID)
+	}
+
+	for ch := range clients[owner] {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// PublishUpload is Publish for callers that only have the id of the upload
+func PublishUpload(id string, update Update) {
+	upload, err := db.Default.GetUpload(id)
+	if err != nil {
+		slog.Debug("no progress for unknown upload", "upload_id", id, "error", err)
+		return
+	}
+	update.UploadID, update.Kind = id, upload.Kind
+	Publish(upload.Owner, update)
+}
+
+// Forget drops the state of an upload that ended without being ready or failing, such as a terminated upload
+func Forget(owner, id string) {
+	mu.Lock()
+	defer mu.Unlock()
+	forget(owner, id)
+}
+
+// forget drops the state of an upload, mu must be held
+func forget(owner, id string) {
+	delete(latest[owner], id)
+	if len(latest[owner]) == 0 {
+		delete(latest, owner)
+	}
+}
+
+// subscribe registers a stream of the owner and returns it with the current state of the owner's uploads
+func subscribe(owner string) (chan Update, []Update) {
+	ch := make(chan Update, clientBuffer)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if clients[owner] == nil {
+		clients[owner] = map[chan Update]bool{}
+	}
+	clients[owner][ch] = true
+
+	current := make([]Update, 0, len(latest[owner]))
+	for _, update := range latest[owner] {
+		current = append(current, update)
+	}
+	return ch, current
+}
+
+// unsubscribe removes a stream registered by subscribe
+func unsubscribe(owner string, ch chan Update) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(clients[owner], ch)
+	if len(clients[owner]) == 0 {
+		delete(clients, owner)
+	}
+}
+
+// overall maps the progress of a stage onto the whole lifecycle
+func overall(update Update) float64 {
+	share, ok := uploadShare[update.Kind]
+	if !ok {
+		share = uploadShare[db.KindVideo]
+	}
+
+	switch update.Stage {
+	case StageUploading:
+		return update.Percent * share / 100
+	case StageProcessing:
+		return share + update.Percent*(100-share)/100
+	default:
+		return 100
+	}
+}
@@ -112,4 +112,5 @@ if [ $? -eq 0 ]; then
     ffmpeg -i "$VIDEO_IN" -ss "$TIMESTAMP" -vframes 1 "$out_dir/thumbnail.jpg" -y
 else
     echo "Transcoding failed. Thumbnail will not be created."
+    exit 1
 fi
\ No newline at end of file
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tus/tusd/v2/pkg/filelocker"
+	"github.com/tus/tusd/v2/pkg/filestore"
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// local keeps everything on the mounted volume: the uploads are stored by tusd's filestore and
+// the local directories are served as they are, so fetching, publishing and removing are no-ops
+type local struct{}
+
+func (local) UseIn(composer *handler.StoreComposer, kind string) {
+	filestore.New(UploadDir(kind)).UseIn(composer)
+	filelocker.New(UploadDir(kind)).UseIn(composer)
+}
+
+func (local) Fetch(ctx context.Context, kind, id string) error {
+	return nil
+}
+
+func (local) RemoveUpload(ctx context.Context, kind, id string) error {
+	return nil
+}
+
+func (local) Publish(ctx context.Context, area Area, name string) error {
+	return nil
+}
+
+func (local) Remove(ctx context.Context, area Area, prefix string) error {
+	return nil
+}
+
+// List walks the directory of area, a missing directory has no files
+func (local) List(ctx context.Context, area Area, prefix string) ([]File, error) {
+	root := Dir(area)
+	var files []File
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// Uploads reads the .info files of filestore. tusd writes the offset into them when the upload is
+// created and never updates it, it is taken from the size of the data instead. The transcoder removes
+// the data of a finished video and keeps its .info, such an upload is reported as fully received.
+func (local) Uploads(ctx context.Context, kind string) ([]handler.FileInfo, error) {
+	infoFiles, err := filepath.Glob(UploadDir(kind) + "*.info")
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make([]handler.FileInfo, 0, len(infoFiles))
+	for _, infoFile := range infoFiles {
+		var upload handler.FileInfo
+		data, err := os.ReadFile(infoFile)
+		if err == nil {
+			err = json.Unmarshal(data, &upload)
+		}
+		if err != nil {
+			slog.Warn("skipping unreadable upload info", "path", infoFile, "error", err)
+			continue
+		}
+
+		stat, err := os.Stat(strings.TrimSuffix(infoFile, ".info"))
+		switch {
+		case err == nil:
+			upload.Offset = stat.Size()
+		case errors.Is(err, fs.ErrNotExist) && !upload.SizeIsDeferred:
+			upload.Offset = upload.Size
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+func (local) FileSystem(area Area) http.FileSystem {
+	return http.Dir(Dir(area))
+}
+
+func (local) Check(ctx context.Context) error {
+	return nil
+}
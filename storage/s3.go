@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/tus/tusd/v2/pkg/handler"
+	"github.com/tus/tusd/v2/pkg/memorylocker"
+	"github.com/tus/tusd/v2/pkg/s3store"
+)
+
+// s3Backend stores the uploads through tusd's s3store and publishes the outputs as objects of
+// config.S3Bucket, under <prefix>videos/, <prefix>images/, <prefix>hls/ and <prefix>thumbnail/.
+// Any S3-compatible service works, config.S3Endpoint and config.S3PathStyle point it at MinIO or the like.
+type s3Backend struct {
+	client *s3.Client
+	bucket string
+	prefix string
+	// stores holds the tusd store of the uploads of each kind
+	stores map[string]s3store.S3Store
+}
+
+// newS3 connects to the bucket of config.S3Bucket. Credentials are read the way the AWS SDK does
+// it, from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or the shared configuration files.
+func newS3(ctx context.Context) (*s3Backend, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(config.S3Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load S3 configuration: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if config.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.S3Endpoint)
+		}
+		o.UsePathStyle = config.S3PathStyle
+	})
+
+	return newS3Backend(client, config.S3Bucket, config.S3Prefix)
+}
+
+// newS3Backend uses client for the objects of bucket whose keys start with prefix
+func newS3Backend(client *s3.Client, bucket, prefix string) (*s3Backend, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	b := &s3Backend{client: client, bucket: bucket, prefix: prefix, stores: map[string]s3store.S3Store{}}
+	for _, kind := range []string{db.KindVideo, db.KindImage} {
+		// s3store buffers the parts of an upload on disk before sending them
+		if err := os.MkdirAll(UploadDir(kind), os.ModePerm); err != nil {
+			return nil, err
+		}
+		store := s3store.New(b.bucket, client)
+		store.ObjectPrefix = b.uploadPrefix(kind)
+		store.TemporaryDirectory = UploadDir(kind)
+		b.stores[kind] = store
+	}
+	return b, nil
+}
+
+// uploadPrefix returns the prefix of the keys of the uploads of the given kind, videos/ or images/
+func (b *s3Backend) uploadPrefix(kind string) string {
+	return b.prefix + path.Base(UploadDir(kind)) + "/"
+}
+
+// areaKey returns the key of a published file of area
+func (b *s3Backend) areaKey(area Area, name string) string {
+	return b.prefix + string(area) + "/" + name
+}
+
+// UseIn uses the s3store of the kind, with an in-memory locker: the locks do not outlive the
+// process and are not shared with other instances
+func (b *s3Backend) UseIn(composer *handler.StoreComposer, kind string) {
+	b.stores[kind].UseIn(composer)
+	memorylocker.New().UseIn(composer)
+}
+
+func (b *s3Backend) Fetch(ctx context.Context, kind, id string) error {
+	store := b.stores[kind]
+	upload, err := store.GetUpload(ctx, id)
+	if err != nil {
+		return notExist(err)
+	}
+	info, err := upload.GetInfo(ctx)
+	if err != nil {
+		return notExist(err)
+	}
+	reader, err := upload.GetReader(ctx)
+	if err != nil {
+		return notExist(err)
+	}
+	defer reader.Close()
+
+	dataPath := UploadDir(kind) + id
+	if err := writeFile(dataPath, reader); err != nil {
+		return err
+	}
+
+	// Written the way tusd's filestore does, so the local files look the same with either backend
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return writeFile(dataPath+".info", bytes.NewReader(data))
+}
+
+// RemoveUpload aborts the multipart upload of an unfinished upload and deletes the objects
+// s3store keeps for it. The keys follow the layout of s3store: the object id is the part of the
+// upload id before the "+", the data is stored under it and the metadata next to it.
+func (b *s3Backend) RemoveUpload(ctx context.Context, kind, id string) error {
+	objectID, multipartID, ok := strings.Cut(id, "+")
+	if !ok {
+		return fmt.Errorf("%w: invalid upload id %s", fs.ErrNotExist, id)
+	}
+	key := b.uploadPrefix(kind) + objectID
+
+	_, err := b.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(b.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(multipartID),
+	})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	return b.deleteObjects(ctx, []types.ObjectIdentifier{
+		{Key: aws.String(key)},
+		{Key: aws.String(key + ".info")},
+		{Key: aws.String(key + ".part")},
+	})
+}
+
+// Publish puts every file of the tree, playlists after the segments they list and the master
+// playlist last, so that a client never finds a playlist whose files are not there yet
+func (b *s3Backend) Publish(ctx context.Context, area Area, name string) error {
+	root := Dir(area)
+	var files []string
+	err := filepath.WalkDir(filepath.Join(root, name), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip the temporary files of writes in progress
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortStableFunc(files, func(a, b string) int {
+		return cmp.Compare(publishOrder(a), publishOrder(b))
+	})
+	for _, file := range files {
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		if err := b.put(ctx, b.areaKey(area, filepath.ToSlash(rel)), file); err != nil {
+			return fmt.Errorf("failed to publish %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// publishOrder ranks a file in the order of publication
+func publishOrder(file string) int {
+	switch {
+	case filepath.Base(file) == "master.m3u8":
+		return 2
+	case filepath.Ext(file) == ".m3u8":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// put uploads a local file as the object key, with the headers the file server would send so
+// that a CDN serving the bucket directly caches it the same way
+func (b *s3Backend) put(ctx context.Context, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(b.bucket),
+		Key:           aws.String(key),
+		Body:          f,
+		ContentLength: aws.Int64(stat.Size()),
+		ContentType:   aws.String(ContentType(file)),
+		CacheControl:  aws.String(CacheControl(file)),
+	})
+	return err
+}
+
+func (b *s3Backend) Remove(ctx context.Context, area Area, prefix string) error {
+	// An empty prefix would remove the whole area
+	if prefix == "" {
+		return errors.New("refusing to remove everything published")
+	}
+
+	return b.listObjects(ctx, b.areaKey(area, prefix), func(page []types.Object) error {
+		// A page holds at most 1000 keys, as many as DeleteObjects accepts
+		objects := make([]types.ObjectIdentifier, 0, len(page))
+		for _, object := range page {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+		return b.deleteObjects(ctx, objects)
+	})
+}
+
+func (b *s3Backend) List(ctx context.Context, area Area, prefix string) ([]File, error) {
+	var files []File
+	err := b.listObjects(ctx, b.areaKey(area, prefix), func(page []types.Object) error {
+		for _, object := range page {
+			files = append(files, File{
+				Name:    strings.TrimPrefix(aws.ToString(object.Key), b.areaKey(area, "")),
+				Size:    aws.ToInt64(object.Size),
+				ModTime: aws.ToTime(object.LastModified),
+			})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Uploads reads the .info objects s3store keeps next to the uploads for their ids, and asks s3store
+// for their current info, whose offset comes from the parts received
+func (b *s3Backend) Uploads(ctx context.Context, kind string) ([]handler.FileInfo, error) {
+	var keys []string
+	err := b.listObjects(ctx, b.uploadPrefix(kind), func(page []types.Object) error {
+		for _, object := range page {
+			if key := aws.ToString(object.Key); strings.HasSuffix(key, ".info") {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make([]handler.FileInfo, 0, len(keys))
+	for _, key := range keys {
+		upload, err := b.upload(ctx, kind, key)
+		if err != nil {
+			slog.Warn("skipping unreadable upload info", "key", key, "error", err)
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// upload returns the current info of the upload whose .info object is key
+func (b *s3Backend) upload(ctx context.Context, kind, key string) (handler.FileInfo, error) {
+	var stored handler.FileInfo
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)})
+	if err != nil {
+		return stored, err
+	}
+	defer out.Body.Close()
+	if err := json.NewDecoder(out.Body).Decode(&stored); err != nil {
+		return stored, err
+	}
+
+	upload, err := b.stores[kind].GetUpload(ctx, stored.ID)
+	if err != nil {
+		return stored, err
+	}
+	return upload.GetInfo(ctx)
+}
+
+// listObjects calls fn with every page of the objects whose key starts with prefix
+func (b *s3Backend) listObjects(ctx context.Context, prefix string, fn func(page []types.Object) error) error {
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		if err := fn(page.Contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteObjects deletes objects of the bucket, objects that do not exist are not an error
+func (b *s3Backend) deleteObjects(ctx context.Context, objects []types.ObjectIdentifier) error {
+	out, err := b.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(b.bucket),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, objectErr := range out.Errors {
+		if aws.ToString(objectErr.Code) != "NoSuchKey" {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %s", aws.ToString(objectErr.Key), aws.ToString(objectErr.Message)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *s3Backend) FileSystem(area Area) http.FileSystem {
+	return s3FileSystem{backend: b, area: area}
+}
+
+func (b *s3Backend) Check(ctx context.Context) error {
+	_, err := b.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(b.bucket)})
+	return err
+}
+
+// writeFile writes the content of r to path through a temporary file, so that path is either
+// complete or untouched
+func writeFile(path string, r io.Reader) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(temp, r)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// notExist reports the uploads tusd does not find as fs.ErrNotExist
+func notExist(err error) error {
+	var tusErr handler.Error
+	if errors.As(err, &tusErr) && tusErr.ErrorCode == handler.ErrNotFound.ErrorCode {
+		return fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return err
+}
+
+// isNotFound reports whether S3 answered that the object or multipart upload does not exist
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "NotFound", "NoSuchKey", "NoSuchUpload":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// fakeS3 is an in-memory bucket speaking the part of the S3 API the backend and s3store use
+type fakeS3 struct {
+	bucket string
+	// pageSize is the number of keys per ListObjectsV2 page
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	// puts lists the keys in the order they were put
+	puts []string
+	// parts holds the part sizes of the multipart uploads in progress, by upload id
+	parts map[string][]int64
+}
+
+type fakeObject struct {
+	body         []byte
+	contentType  string
+	cacheControl string
+	modified     time.Time
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		f.list(w, query.Get("prefix"), query.Get("continuation-token"))
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		f.delete(w, r)
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		f.listParts(w, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.parts, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeObject{body: body, contentType: r.Header.Get("Content-Type"), cacheControl: r.Header.Get("Cache-Control"), modified: time.Now()}
+		f.puts = append(f.puts, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(object.body)))
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(object.body)
+		}
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix, after string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		Size         int64
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{Name: f.bucket, Prefix: prefix}
+
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		result.IsTruncated, result.NextContinuationToken = true, keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		object := f.objects[key]
+		result.Contents = append(result.Contents, content{Key: key, Size: int64(len(object.body)), LastModified: object.modified.UTC().Format(time.RFC3339)})
+	}
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+func (f *fakeS3) delete(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Objects []struct{ Key string } `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	for _, object := range request.Objects {
+		delete(f.objects, object.Key)
+	}
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+	}{})
+}
+
+func (f *fakeS3) listParts(w http.ResponseWriter, uploadID string) {
+	sizes, ok := f.parts[uploadID]
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	type part struct {
+		PartNumber int32
+		Size       int64
+		ETag       string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"ListPartsResult"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, size := range sizes {
+		result.Parts = append(result.Parts, part{PartNumber: int32(i + 1), Size: size, ETag: fmt.Sprintf(`"%d"`, i+1)})
+	}
+	writeXML(w, result)
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// newFakeS3 returns an s3 backend using the prefix media/ of a fake bucket, with the local
+// directories moved to a temporary directory
+func newFakeS3(t *testing.T) (*s3Backend, *fakeS3) {
+	t.Helper()
+	root := t.TempDir()
+	for dir, value := range map[*string]string{
+		&config.VideosDir:    "videos",
+		&config.ImagesDir:    "images",
+		&config.HLSDir:       "hls",
+		&config.ThumbnailDir: "thumbnail",
+	} {
+		previous := *dir
+		*dir = filepath.Join(root, value) + "/"
+		t.Cleanup(func() { *dir = previous })
+	}
+
+	fake := &fakeS3{bucket: "bucket", pageSize: 2, objects: map[string]fakeObject{}, parts: map[string][]int64{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	backend, err := newS3Backend(client, fake.bucket, "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend, fake
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3PublishListRemove(t *testing.T) {
+	backend, fake := newFakeS3(t)
+	ctx := context.Background()
+
+	writeTestFile(t, config.HLSDir+"abc/master.m3u8", "#EXTM3U")
+	writeTestFile(t, config.HLSDir+"abc/720p.m3u8", "#EXTM3U")
+	writeTestFile(t, config.HLSDir+"abc/720p_000.ts", "segment0")
+	writeTestFile(t, config.HLSDir+"abc/720p_001.ts", "segment1")
+	writeTestFile(t, config.HLSDir+"abc/.720p_002.ts-tmp", "partial")
+	writeTestFile(t, config.HLSDir+"abd/master.m3u8", "#EXTM3U")
+	for _, name := range []string{"abc", "abd"} {
+		if err := backend.Publish(ctx, HLS, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantPuts := []string{
+		"media/hls/abc/720p_000.ts", "media/hls/abc/720p_001.ts", "media/hls/abc/720p.m3u8", "media/hls/abc/master.m3u8",
+		"media/hls/abd/master.m3u8",
+	}
+	if !slices.Equal(fake.puts, wantPuts) {
+		t.Fatalf("puts = %v, want %v", fake.puts, wantPuts)
+	}
+	if object := fake.objects["media/hls/abc/720p_000.ts"]; object.contentType != "video/mp2t" || object.cacheControl != CacheControl("720p_000.ts") {
+		t.Errorf("segment published with Content-Type %q and Cache-Control %q", object.contentType, object.cacheControl)
+	}
+
+	// Two keys per page, the listing has to follow the continuation tokens
+	files, err := backend.List(ctx, HLS, "abc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+		if file.ModTime.IsZero() {
+			t.Errorf("%s has no modification time", file.Name)
+		}
+	}
+	wantNames := []string{"abc/720p.m3u8", "abc/720p_000.ts", "abc/720p_001.ts", "abc/master.m3u8"}
+	if !slices.Equal(names, wantNames) {
+		t.Fatalf("List = %v, want %v", names, wantNames)
+	}
+	if files[1].Size != int64(len("segment0")) {
+		t.Errorf("size of %s = %d, want %d", files[1].Name, files[1].Size, len("segment0"))
+	}
+
+	if err := backend.Remove(ctx, HLS, ""); err == nil {
+		t.Error("Remove with an empty prefix succeeded")
+	}
+	if err := backend.Remove(ctx, HLS, "abc/"); err != nil {
+		t.Fatal(err)
+	}
+	files, err = backend.List(ctx, HLS, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "abd/master.m3u8" {
+		t.Errorf("after Remove, List = %v, want only abd/master.m3u8", files)
+	}
+}
+
+func TestS3Uploads(t *testing.T) {
+	backend, fake := newFakeS3(t)
+
+	putInfo := func(objectID string, info handler.FileInfo) {
+		data, err := json.Marshal(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fake.objects["media/videos/"+objectID+".info"] = fakeObject{body: data, modified: time.Now()}
+	}
+	// In progress, two parts received
+	putInfo("one", handler.FileInfo{ID: "one+mp1", Size: 100})
+	fake.parts["mp1"] = []int64{30, 20}
+	// Finished, s3store completed the multipart upload
+	putInfo("two", handler.FileInfo{ID: "two+mp2", Size: 70})
+	fake.objects["media/videos/two"] = fakeObject{body: make([]byte, 70), modified: time.Now()}
+	// Another kind
+	fake.objects["media/images/three.info"] = fakeObject{body: []byte(`{"ID":"three+mp3","Size":5}`), modified: time.Now()}
+
+	uploads, err := backend.Uploads(context.Background(), db.KindVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(uploads, func(a, b handler.FileInfo) int { return strings.Compare(a.ID, b.ID) })
+
+	want := []struct {
+		id     string
+		offset int64
+	}{{"one+mp1", 50}, {"two+mp2", 70}}
+	if len(uploads) != len(want) {
+		t.Fatalf("Uploads returned %d uploads, want %d", len(uploads), len(want))
+	}
+	for i, upload := range uploads {
+		if upload.ID != want[i].id || upload.Offset != want[i].offset {
+			t.Errorf("upload %d = %s at %d, want %s at %d", i, upload.ID, upload.Offset, want[i].id, want[i].offset)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3FileSystem serves the published objects of an area to http.FileServer. A bucket has no
+// directories, only objects can be opened.
+type s3FileSystem struct {
+	backend *s3Backend
+	area    Area
+}
+
+func (fsys s3FileSystem) Open(name string) (http.File, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil, fs.ErrNotExist
+	}
+
+	key := fsys.backend.areaKey(fsys.area, name)
+	head, err := fsys.backend.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(fsys.backend.bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3File{
+		backend: fsys.backend,
+		key:     key,
+		etag:    aws.ToString(head.ETag),
+		info:    objectInfo{name: path.Base(name), size: aws.ToInt64(head.ContentLength), modTime: aws.ToTime(head.LastModified)},
+	}, nil
+}
+
+// s3File reads an object from the current offset on, the object is only fetched once read
+// and fetched again from the new offset after a seek
+type s3File struct {
+	backend *s3Backend
+	key     string
+	// etag keeps the reads on the version of the object that was opened
+	etag   string
+	info   objectInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		out, err := f.backend.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket:  aws.String(f.backend.bucket),
+			Key:     aws.String(f.key),
+			IfMatch: aws.String(f.etag),
+			Range:   aws.String(fmt.Sprintf("bytes=%d-", f.offset)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		f.body = out.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the object")
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+func (f *s3File) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, fs.ErrInvalid
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// objectInfo describes an object as a read-only file
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) Mode() fs.FileMode  { return 0444 }
+func (i objectInfo) ModTime() time.Time { return i.modTime }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() any           { return nil }
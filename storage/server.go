@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/LinuxSploit/TusAce/logging"
+)
+
+// maxPlaylistSize bounds the playlists read into memory, HLS playlists are a few kilobytes
+const maxPlaylistSize = 4 << 20
+
+// maxGzipCache is how many compressed playlists are kept before the cache starts over
+const maxGzipCache = 1024
+
+// gzipCache holds compressed playlists by the hash of their content, so that a playlist is
+// compressed once however often it is requested
+var (
+	gzipMu    sync.Mutex
+	gzipCache = map[string][]byte{}
+)
+
+// FileServer serves the published files of fsys with the content types and caching headers of
+// ContentType and CacheControl. Segments and thumbnails are served as they are, with range requests
+// and an ETag from their size and modification time. Playlists get an ETag from their content and
+// are gzipped for the clients that accept it. Directories are not listed.
+func FileServer(fsys http.FileSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			serveError(w, r, name, err)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			serveError(w, r, name, err)
+			return
+		}
+		if info.IsDir() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Content-Type", ContentType(name))
+		header.Set("Cache-Control", CacheControl(name))
+		header.Set("X-Content-Type-Options", "nosniff")
+
+		if path.Ext(name) == ".m3u8" {
+			servePlaylist(w, r, name, info, file)
+			return
+		}
+
+		header.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		http.ServeContent(w, r, name, info.ModTime(), file)
+	})
+}
+
+// servePlaylist serves a playlist from memory, compressed if the client accepts gzip
+func servePlaylist(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo, file io.Reader) {
+	body, err := io.ReadAll(io.LimitReader(file, maxPlaylistSize+1))
+	if err == nil && len(body) > maxPlaylistSize {
+		err = errors.New("playlist too large")
+	}
+	if err != nil {
+		serveError(w, r, name, err)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	tag := hex.EncodeToString(sum[:16])
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(r) {
+		body, err = gzipped(tag, body)
+		if err != nil {
+			serveError(w, r, name, err)
+			return
+		}
+		// The compressed representation needs an ETag of its own
+		tag += "-gzip"
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("ETag", `"`+tag+`"`)
+
+	http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(body))
+}
+
+// acceptsGzip reports whether the Accept-Encoding of the request allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if params == "" {
+			return true
+		}
+		q, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+		return err == nil && q > 0
+	}
+	return false
+}
+
+// gzipped returns the compressed body of a playlist, from the cache if it was compressed before
+func gzipped(tag string, body []byte) ([]byte, error) {
+	gzipMu.Lock()
+	compressed, ok := gzipCache[tag]
+	gzipMu.Unlock()
+	if ok {
+		return compressed, nil
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	gzipMu.Lock()
+	if len(gzipCache) >= maxGzipCache {
+		gzipCache = map[string][]byte{}
+	}
+	gzipCache[tag] = buf.Bytes()
+	gzipMu.Unlock()
+	return buf.Bytes(), nil
+}
+
+// serveError answers 404 for missing files, 403 for forbidden ones and logs anything else
+func serveError(w http.ResponseWriter, r *http.Request, name string, err error) {
+	// Errors must not be cached like the file would have been
+	for _, key := range []string{"Cache-Control", "Content-Encoding", "ETag"} {
+		w.Header().Del(key)
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		logging.FromContext(r.Context()).Error("failed to serve media file", "path", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// Area is a group of published media, served under /<area>/
+type Area string
+
+const (
+	// HLS holds one directory per video, named after the upload id
+	HLS Area = "hls"
+	// Thumbnail holds the image variants and video posters, named <id>-<variant>.webp
+	Thumbnail Area = "thumbnail"
+)
+
+// Backend stores the uploads and the media published from them. The transcoder and the image
+// processing work in the local directories of config whichever backend is used: uploads are
+// fetched into the upload directory of their kind and outputs are written to the directory of their
+// area, from where the backend publishes them.
+type Backend interface {
+	// UseIn sets up the tusd store and locker of the uploads of the given kind
+	UseIn(composer *handler.StoreComposer, kind string)
+	// Fetch copies the data and .info of a finished upload into UploadDir(kind). An upload that
+	// does not exist is reported as fs.ErrNotExist.
+	Fetch(ctx context.Context, kind, id string) error
+	// RemoveUpload deletes an upload from the backend, the files in UploadDir(kind) are left to the caller
+	RemoveUpload(ctx context.Context, kind, id string) error
+	// Publish stores the file or directory tree name of the directory of area, overwriting the files
+	// published under the same names
+	Publish(ctx context.Context, area Area, name string) error
+	// Remove deletes the published files of area whose name starts with prefix
+	Remove(ctx context.Context, area Area, prefix string) error
+	// List returns the published files of area whose name starts with prefix
+	List(ctx context.Context, area Area, prefix string) ([]File, error)
+	// Uploads returns the info of every upload of the given kind stored in the backend, with the
+	// offset received so far
+	Uploads(ctx context.Context, kind string) ([]handler.FileInfo, error)
+	// FileSystem serves the published files of area
+	FileSystem(area Area) http.FileSystem
+	// Check verifies that the backend is reachable
+	Check(ctx context.Context) error
+}
+
+// File is a published file, its Name is its path within the area such as <id>/master.m3u8
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Default is the backend selected by config.StorageBackend, set by main
+var Default Backend
+
+// Open returns the backend selected by config.StorageBackend
+func Open(ctx context.Context) (Backend, error) {
+	switch config.StorageBackend {
+	case "s3":
+		if config.S3Bucket == "" {
+			return nil, fmt.Errorf("the s3 storage backend needs S3_BUCKET")
+		}
+		return newS3(ctx)
+	default:
+		return local{}, nil
+	}
+}
+
+// UploadDir returns the local directory of the uploads of the given kind
+func UploadDir(kind string) string {
+	if kind == db.KindVideo {
+		return config.VideosDir
+	}
+	return config.ImagesDir
+}
+
+// Dir returns the local directory the outputs of area are written to
+func Dir(area Area) string {
+	if area == HLS {
+		return config.HLSDir
+	}
+	return config.ThumbnailDir
+}
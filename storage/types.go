@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+)
+
+// contentTypes are the types of the published files by extension, so that they do not depend on
+// the mime database of the OS
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt",
+	".webp": "image/webp",
+	".jpg":  "image/jpeg",
+}
+
+// segmentExtensions are the extensions of HLS segments and init sections
+var segmentExtensions = map[string]bool{".ts": true, ".m4s": true, ".mp4": true, ".aac": true}
+
+// ContentType returns the content type of a published file
+func ContentType(name string) string {
+	if contentType, ok := contentTypes[strings.ToLower(path.Ext(name))]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// CacheControl returns the Cache-Control header of a published file: segments are cached for good,
+// playlists briefly and thumbnails for config.ThumbnailMaxAge
+func CacheControl(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case ext == ".m3u8":
+		return maxAge(config.PlaylistMaxAge)
+	case segmentExtensions[ext]:
+		return maxAge(config.SegmentMaxAge) + ", immutable"
+	default:
+		return maxAge(config.ThumbnailMaxAge)
+	}
+}
+
+// maxAge formats a public Cache-Control with the given lifetime
+func maxAge(d time.Duration) string {
+	return fmt.Sprintf("public, max-age=%d", int64(d.Seconds()))
+}
@@ -0,0 +1,109 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Errors that retrying a job cannot fix, any other error is treated as transient, such as a full
+// disk or a failed rename. A timeout is not retried either, the timeout already leaves ample room.
+var (
+	// ErrCorruptInput is returned when ffprobe or ffmpeg cannot read the uploaded video
+	ErrCorruptInput = errors.New("corrupt input")
+	// ErrMissingInput is returned when the file to transcode does not exist anymore
+	ErrMissingInput = errors.New("missing input")
+	// ErrTimeout is returned when the encode ran longer than the timeout of its job
+	ErrTimeout = errors.New("timed out")
+)
+
+// Error classes recorded with dead letters
+const (
+	ClassTransient    = "transient"
+	ClassCorruptInput = "corrupt_input"
+	ClassMissingInput = "missing_input"
+	ClassTimeout      = "timeout"
+	// ClassCancelled marks jobs an admin cancelled, they are dead-lettered so they can be requeued
+	ClassCancelled = "cancelled"
+)
+
+// stderrTailSize is how much of the end of the ffmpeg script output is kept for a failed job
+const stderrTailSize = 4096
+
+// corruptInputMarkers appear in the ffmpeg and ffprobe output when the input is not a readable video
+var corruptInputMarkers = []string{
+	"Invalid data found when processing input",
+	"moov atom not found",
+	"could not find codec parameters",
+	"Failed to retrieve video dimensions",
+	"does not contain any stream",
+	"Output file #0 does not contain any stream",
+	"Stream map 'v:0' matches no streams",
+}
+
+// ScriptError is returned when the ffmpeg script exits with an error, it carries the end of its output
+type ScriptError struct {
+	Err        error
+	StderrTail string
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("failed to run FFmpeg script: %v", e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// classify wraps a script error with ErrCorruptInput or ErrMissingInput when its output shows that
+// the input cannot be transcoded
+func classify(err *ScriptError) error {
+	if strings.Contains(err.StderrTail, "No such file or directory") {
+		return fmt.Errorf("%w: %w", ErrMissingInput, err)
+	}
+	for _, marker := range corruptInputMarkers {
+		if strings.Contains(err.StderrTail, marker) {
+			return fmt.Errorf("%w: %w", ErrCorruptInput, err)
+		}
+	}
+	return err
+}
+
+// errorClass returns the class of a job error
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrCorruptInput):
+		return ClassCorruptInput
+	case errors.Is(err, ErrMissingInput):
+		return ClassMissingInput
+	case errors.Is(err, ErrTimeout):
+		return ClassTimeout
+	default:
+		return ClassTransient
+	}
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,66 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		name   string
+		stderr string
+		want   error
+	}{
+		{"missing input", "input.mp4: No such file or directory", ErrMissingInput},
+		{"unreadable input", "[mov,mp4] moov atom not found\ninput.mp4: Invalid data found when processing input", ErrCorruptInput},
+		{"no video stream", "Stream map 'v:0' matches no streams.", ErrCorruptInput},
+		{"no dimensions", "Failed to retrieve video dimensions", ErrCorruptInput},
+		{"full disk", "Error writing trailer: No space left on device", nil},
+		{"no output", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scriptErr := &ScriptError{Err: exit, StderrTail: tt.stderr}
+			err := classify(scriptErr)
+
+			// The script error stays in the chain, with its output
+			var unwrapped *ScriptError
+			if !errors.As(err, &unwrapped) || unwrapped != scriptErr {
+				t.Errorf("classify lost the script error: %v", err)
+			}
+			for _, sentinel := range []error{ErrMissingInput, ErrCorruptInput} {
+				if got, want := errors.Is(err, sentinel), sentinel == tt.want; got != want {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", err, sentinel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"corrupt input", classify(&ScriptError{Err: errors.New("exit status 1"), StderrTail: "moov atom not found"}), ClassCorruptInput},
+		{"missing input", fmt.Errorf("%w: %w", ErrMissingInput, os.ErrNotExist), ClassMissingInput},
+		{"timeout", fmt.Errorf("encode of 720p: %w", ErrTimeout), ClassTimeout},
+		{"script failure", &ScriptError{Err: errors.New("exit status 137")}, ClassTransient},
+		{"cancelled", context.Canceled, ClassTransient},
+		{"rename", &os.LinkError{Op: "rename", Err: errors.New("cross-device link")}, ClassTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorClass(tt.err); got != tt.want {
+				t.Errorf("errorClass(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
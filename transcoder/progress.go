@@ -0,0 +1,66 @@
+package transcoder
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// maxProgressLine bounds the partial line kept between writes, ffmpeg status lines are far shorter
+const maxProgressLine = 4096
+
+// ffmpegTime matches the position ffmpeg reports in its status lines, e.g. "time=00:01:23.45"
+var ffmpegTime = regexp.MustCompile(`time=(\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// progressWriter reads the status lines ffmpeg writes to stderr and reports the position encoded so far.
+// ffmpeg ends its status lines with a carriage return, so both line endings are accepted.
+type progressWriter struct {
+	onTime func(encoded time.Duration)
+	line   []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		end := bytes.IndexAny(w.line, "\r\n")
+		if end < 0 {
+			break
+		}
+		w.parse(w.line[:end])
+		w.line = w.line[end+1:]
+	}
+	if len(w.line) > maxProgressLine {
+		w.line = w.line[len(w.line)-maxProgressLine:]
+	}
+	return len(p), nil
+}
+
+// parse reports the position of a status line, other lines are ignored
+func (w *progressWriter) parse(line []byte) {
+	match := ffmpegTime.FindSubmatch(line)
+	if match == nil {
+		return
+	}
+	hours, _ := strconv.Atoi(string(match[1]))
+	minutes, _ := strconv.Atoi(string(match[2]))
+	seconds, _ := strconv.ParseFloat(string(match[3]), 64)
+	w.onTime(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second)))
+}
+
+// percentReporter turns encoded positions of a source of the given duration into whole percentages,
+// calling report only when the percentage grows. It returns nil when there is nothing to report to.
+func percentReporter(duration time.Duration, report func(percent float64)) func(time.Duration) {
+	if report == nil || duration <= 0 {
+		return nil
+	}
+
+	last := -1
+	return func(encoded time.Duration) {
+		percent := min(int(100*encoded/duration), 100)
+		if percent > last {
+			last = percent
+			report(float64(percent))
+		}
+	}
+}
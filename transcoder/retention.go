@@ -0,0 +1,238 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Names of the files inside the archive directory of an upload
+const (
+	archivedData           = "original"
+	archivedCompressedData = "original.zst"
+)
+
+// RetainOriginal applies config.OriginalRetention to the tusd files of a transcoded upload.
+// Kept and archived originals are recorded as the original derivative of the upload, the upload
+// metadata stays in the database whichever policy is used.
+func RetainOriginal(id, inputPath string) error {
+	dataPath := inputPath + id
+
+	switch config.OriginalRetention {
+	case "keep":
+		return recordOriginal(id, archivedData, dataPath, dataPath)
+
+	case "archive":
+		archiveDir := filepath.Join(config.ArchiveDir, id)
+		if err := os.MkdirAll(archiveDir, os.ModePerm); err != nil {
+			return err
+		}
+
+		name := archivedData
+		if config.ArchiveCompression == "zstd" {
+			name = archivedCompressedData
+			if err := compressFile(dataPath, filepath.Join(archiveDir, name)); err != nil {
+				return err
+			}
+			if err := os.Remove(dataPath); err != nil {
+				return err
+			}
+		} else if err := moveFile(dataPath, filepath.Join(archiveDir, name)); err != nil {
+			return err
+		}
+
+		// The .info stays in inputPath, like with "delete", so media.ImportUploads still finds the upload
+		return recordOriginal(id, name, archiveDir, filepath.Join(archiveDir, name))
+
+	default:
+		// The .info is kept so the upload can be imported again into a new database by media.ImportUploads
+		if err := os.Remove(dataPath); err != nil {
+			return fmt.Errorf("failed to remove temporary uploads: %w", err)
+		}
+		return nil
+	}
+}
+
+// recordOriginal records where the original of an upload is retained. path is removed when the
+// media is purged, dataPath is the file holding the original data.
+func recordOriginal(id, name, path, dataPath string) error {
+	stat, err := os.Stat(dataPath)
+	if err != nil {
+		return err
+	}
+
+	return db.Default.PutDerivatives(id, db.DerivativeOriginal, []db.Derivative{{Kind: db.DerivativeOriginal, Name: name, Path: path, Size: stat.Size()}})
+}
+
+// OpenOriginal opens the retained original of an upload, decompressing it if it was archived with zstd
+func OpenOriginal(id string) (io.ReadCloser, error) {
+	derivatives, err := db.Default.Derivatives(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, derivative := range derivatives {
+		if derivative.Kind != db.DerivativeOriginal {
+			continue
+		}
+
+		file, err := os.Open(originalDataPath(derivative))
+		if err != nil {
+			return nil, err
+		}
+		if derivative.Name != archivedCompressedData {
+			return file, nil
+		}
+
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &zstdFile{Decoder: decoder, file: file}, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// HasOriginal reports whether the original of an upload was retained
+func HasOriginal(id string) bool {
+	_, ok := originalDerivative(id)
+	return ok
+}
+
+// originalDerivative returns the original derivative of an upload, if it was retained
+func originalDerivative(id string) (db.Derivative, bool) {
+	derivatives, err := db.Default.Derivatives(id)
+	if err != nil {
+		return db.Derivative{}, false
+	}
+
+	for _, derivative := range derivatives {
+		if derivative.Kind == db.DerivativeOriginal {
+			return derivative, true
+		}
+	}
+	return db.Derivative{}, false
+}
+
+// originalDataPath returns the file holding the data of a retained original
+func originalDataPath(derivative db.Derivative) string {
+	if info, err := os.Stat(derivative.Path); err == nil && info.IsDir() {
+		return filepath.Join(derivative.Path, derivative.Name)
+	}
+	return derivative.Path
+}
+
+// originalFile returns a path ffmpeg can read the original of an upload from, decompressing a
+// zstd archive into a temporary file. The returned function removes that file.
+func originalFile(id string) (string, func(), error) {
+	derivative, ok := originalDerivative(id)
+	if !ok {
+		return "", nil, os.ErrNotExist
+	}
+	if derivative.Name != archivedCompressedData {
+		return originalDataPath(derivative), func() {}, nil
+	}
+
+	original, err := OpenOriginal(id)
+	if err != nil {
+		return "", nil, err
+	}
+	defer original.Close()
+
+	temp, err := os.CreateTemp(config.VideosDir, id+"-*.original")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(temp.Name()) }
+
+	_, err = io.Copy(temp, original)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return temp.Name(), cleanup, nil
+}
+
+// zstdFile closes both the decoder and the underlying file
+type zstdFile struct {
+	*zstd.Decoder
+	file *os.File
+}
+
+func (f *zstdFile) Close() error {
+	f.Decoder.Close()
+	return f.file.Close()
+}
+
+// compressFile writes the zstd compressed content of src to dst
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	encoder, err := zstd.NewWriter(out)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	_, err = io.Copy(encoder, in)
+	if closeErr := encoder.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// moveFile renames src to dst, copying it when the archive lives on another filesystem
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,82 @@
+package transcoder
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+)
+
+func TestRetainOriginal(t *testing.T) {
+	tests := []struct {
+		name        string
+		retention   string
+		compression string
+		// wantOriginal is where the data is retained, relative to the temporary directory
+		wantOriginal string
+	}{
+		{name: "delete", retention: "delete"},
+		{name: "keep", retention: "keep", wantOriginal: "videos/abc"},
+		{name: "archive", retention: "archive", compression: "none", wantOriginal: "archive/abc/original"},
+		{name: "zstd archive", retention: "archive", compression: "zstd", wantOriginal: "archive/abc/original.zst"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			database, err := db.Open(filepath.Join(root, "media.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			previous := struct {
+				db                                   *db.DB
+				videos, archive, retention, compress string
+			}{db.Default, config.VideosDir, config.ArchiveDir, config.OriginalRetention, config.ArchiveCompression}
+			db.Default = database
+			config.VideosDir, config.ArchiveDir = filepath.Join(root, "videos")+"/", filepath.Join(root, "archive")+"/"
+			config.OriginalRetention, config.ArchiveCompression = test.retention, test.compression
+			t.Cleanup(func() {
+				database.Close()
+				db.Default = previous.db
+				config.VideosDir, config.ArchiveDir = previous.videos, previous.archive
+				config.OriginalRetention, config.ArchiveCompression = previous.retention, previous.compress
+			})
+
+			writeFile(t, config.VideosDir+"abc", "original data")
+			writeFile(t, config.VideosDir+"abc.info", `{"ID":"abc"}`)
+
+			if err := RetainOriginal("abc", config.VideosDir); err != nil {
+				t.Fatalf("RetainOriginal: %v", err)
+			}
+
+			// The upload can be imported again whichever policy is used
+			if _, err := os.Stat(config.VideosDir + "abc.info"); err != nil {
+				t.Errorf("the .info left the uploads directory: %v", err)
+			}
+
+			if test.wantOriginal == "" {
+				if HasOriginal("abc") {
+					t.Error("an original is recorded for a deleted original")
+				}
+				if _, err := os.Stat(config.VideosDir + "abc"); !os.IsNotExist(err) {
+					t.Errorf("the original was not deleted: %v", err)
+				}
+				return
+			}
+			if _, err := os.Stat(filepath.Join(root, test.wantOriginal)); err != nil {
+				t.Errorf("the original is not retained: %v", err)
+			}
+			original, err := OpenOriginal("abc")
+			if err != nil {
+				t.Fatalf("OpenOriginal: %v", err)
+			}
+			defer original.Close()
+			if data, err := io.ReadAll(original); err != nil || string(data) != "original data" {
+				t.Errorf("original = %q, %v, want the uploaded data", data, err)
+			}
+		})
+	}
+}
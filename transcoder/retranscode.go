@@ -0,0 +1,145 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/metrics"
+	"github.com/LinuxSploit/TusAce/storage"
+	"github.com/LinuxSploit/TusAce/webhook"
+)
+
+// EnqueueRetranscode records a queued re-transcode job for a video and adds it to the queue
+func EnqueueRetranscode(id string) {
+	putJob(db.Job{UploadID: id, Type: db.JobRetranscode, Status: db.JobQueued})
+	send(RetranscodeQueue, id)
+}
+
+// Retranscode encodes the retained original of a video into a new version directory of its HLS output,
+// v1, v2 and so on. The live master.m3u8 is only swapped to the new version once the encode succeeded.
+// The previous output is kept for players still using it, the janitor removes it once
+// config.SupersededHLSRetention passed. It returns the name of the new version directory.
+func Retranscode(ctx context.Context, id, output_path string) (string, error) {
+	input, cleanup, err := originalFile(id)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrMissingInput, err)
+	}
+	defer cleanup()
+
+	hlsDir := output_path + id
+	version := nextVersion(hlsDir)
+	versionDir := filepath.Join(hlsDir, version)
+	if err := encodeStaged(ctx, input, versionDir, id+"-"+version, nil); err != nil {
+		return "", err
+	}
+
+	if err := swapMaster(hlsDir, version); err != nil {
+		os.RemoveAll(versionDir)
+		return "", fmt.Errorf("failed to publish %s: %w", version, err)
+	}
+
+	// Publish the new version along with the swapped master playlist
+	if err := storage.Default.Publish(ctx, storage.HLS, id); err != nil {
+		return "", fmt.Errorf("failed to publish %s: %w", version, err)
+	}
+
+	return version, nil
+}
+
+// nextVersion returns the name of the next version directory of an HLS output
+func nextVersion(hlsDir string) string {
+	latest := 0
+	entries, _ := os.ReadDir(hlsDir)
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "v")
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		if n, err := strconv.Atoi(name); err == nil && n > latest {
+			latest = n
+		}
+	}
+	return fmt.Sprintf("v%d", latest+1)
+}
+
+// swapMaster atomically replaces the master playlist of hlsDir with the one of its version directory,
+// with the variant playlist URIs rewritten relative to hlsDir
+func swapMaster(hlsDir, version string) error {
+	master, err := os.ReadFile(filepath.Join(hlsDir, version, "master.m3u8"))
+	if err != nil {
+		return err
+	}
+
+	var rewritten bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(master))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = version + "/" + line
+		}
+		rewritten.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	temp := filepath.Join(hlsDir, ".master.m3u8.tmp")
+	if err := os.WriteFile(temp, rewritten.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath.Join(hlsDir, "master.m3u8"))
+}
+
+// processRetranscode runs a re-transcode job and records its outcome. The upload keeps serving its
+// current output while it runs, and keeps it if the job fails.
+func processRetranscode(id, output_path string) {
+	logger := slog.With("upload_id", id, "job", db.JobRetranscode)
+
+	if upload, err := db.Default.GetUpload(id); err != nil || !upload.Deleted.IsZero() {
+		logger.Info("skipping re-transcoding of missing or deleted upload")
+		putJob(db.Job{UploadID: id, Type: db.JobRetranscode, Status: db.JobCancelled, Finished: time.Now().UTC()})
+		return
+	}
+	if job, err := db.Default.GetJob(id, db.JobRetranscode); err == nil && job.Status != db.JobQueued {
+		logger.Info("skipping re-transcoding, the job is no longer queued", "status", job.Status)
+		return
+	}
+
+	logger.Info("starting re-transcoding")
+
+	ctx, done := track(id)
+	defer done()
+
+	job := startJob(id, db.JobRetranscode)
+	version, err := Retranscode(ctx, id, output_path)
+	if err != nil {
+		if interrupted(ctx, job) {
+			return
+		}
+
+		logger.Error("re-transcoding failed", "error", err)
+		if !retryOrDeadLetter(&job, err) {
+			webhook.PublishUpload(webhook.EventTranscodeFailed, id, &job)
+		}
+		return
+	}
+
+	logger.Info("re-transcoding succeeded", "version", version)
+	job.Status, job.Finished = db.JobSucceeded, time.Now().UTC()
+	putJob(job)
+	metrics.TranscodeJobs.WithLabelValues(job.Type, "succeeded").Inc()
+
+	hlsDir := output_path + id
+	recordHLS(id, hlsDir)
+	recordPosters(id, filepath.Join(hlsDir, version, "thumbnail.jpg"))
+	webhook.PublishUpload(webhook.EventTranscodeSucceeded, id, &job)
+}
@@ -0,0 +1,99 @@
+package transcoder
+
+import (
+	"errors"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/metrics"
+)
+
+// retryOrDeadLetter records a failed run of job. Transient errors are retried with exponential backoff
+// until config.TranscodeMaxAttempts is reached, other errors and exhausted jobs are dead-lettered.
+// job is updated to the recorded state. It reports whether the job will be retried.
+func retryOrDeadLetter(job *db.Job, err error) bool {
+	now := time.Now().UTC()
+	job.Error, job.Finished = err.Error(), now
+
+	class := errorClass(err)
+	if class == ClassTransient && job.Attempts < config.TranscodeMaxAttempts {
+		delay := retryDelay(job.Attempts)
+		job.Status, job.NextAttempt = db.JobQueued, now.Add(delay)
+		putJob(*job)
+		metrics.TranscodeJobs.WithLabelValues(job.Type, "retried").Inc()
+
+		jobLogger(*job).Warn("retrying job", "delay", delay.String(), "attempt", job.Attempts+1, "max_attempts", config.TranscodeMaxAttempts)
+		queued := *job
+		time.AfterFunc(delay, func() { requeue(queued) })
+		return true
+	}
+
+	job.Status = db.JobFailed
+	putJob(*job)
+	metrics.TranscodeJobs.WithLabelValues(job.Type, "failed").Inc()
+
+	letter := db.DeadLetter{
+		UploadID: job.UploadID,
+		Type:     job.Type,
+		Class:    class,
+		Error:    err.Error(),
+		Attempts: job.Attempts,
+		Failed:   now,
+	}
+	var scriptErr *ScriptError
+	if errors.As(err, &scriptErr) {
+		letter.StderrTail = scriptErr.StderrTail
+	}
+	if err := db.Default.PutDeadLetter(letter); err != nil {
+		jobLogger(*job).Error("failed to dead-letter job", "error", err)
+	}
+
+	jobLogger(*job).Error("giving up on job", "attempts", job.Attempts, "class", class)
+	return false
+}
+
+// retryDelay returns how long to wait before retrying a job that failed after the given number of
+// attempts. Attempts below one, such as those of jobs requeued by Shutdown, get the initial backoff.
+func retryDelay(attempts int) time.Duration {
+	delay := config.TranscodeRetryBackoff
+	for i := 1; i < attempts && delay < config.TranscodeRetryMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, config.TranscodeRetryMaxBackoff)
+}
+
+// requeue adds a job that is waiting for its retry back to its queue, unless it was requeued or
+// cancelled in the meantime
+func requeue(job db.Job) {
+	current, err := db.Default.GetJob(job.UploadID, job.Type)
+	if err != nil || current.Status != db.JobQueued || !current.NextAttempt.Equal(job.NextAttempt) {
+		return
+	}
+
+	switch job.Type {
+	case db.JobTranscode:
+		send(TranscodeQueue, job.UploadID)
+	case db.JobRetranscode:
+		send(RetranscodeQueue, job.UploadID)
+	}
+}
+
+// RequeueDeadLetter removes a dead letter and queues its job again with fresh attempts
+func RequeueDeadLetter(uploadID, jobType string) error {
+	if _, err := db.Default.GetDeadLetter(uploadID, jobType); err != nil {
+		return err
+	}
+	if err := db.Default.DeleteDeadLetter(uploadID, jobType); err != nil {
+		return err
+	}
+
+	switch jobType {
+	case db.JobTranscode:
+		setStatus(uploadID, db.StatusProcessing)
+		go Enqueue(uploadID)
+	case db.JobRetranscode:
+		go EnqueueRetranscode(uploadID)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/metrics"
+)
+
+// errShutdown is the cancellation cause of a job interrupted by Shutdown
+var errShutdown = errors.New("transcoder shutting down")
+
+var (
+	// stopping is closed by Shutdown, the worker then takes no further job
+	stopping = make(chan struct{})
+	// workerDone is closed once the worker returned
+	workerDone = make(chan struct{})
+)
+
+// send adds id to queue. Once the worker is stopping the id is dropped, its job stays queued in the
+// database and is resumed by ResumeJobs after the next start.
+func send(queue chan<- string, id string) {
+	select {
+	case queue <- id:
+	case <-stopping:
+	}
+}
+
+// Shutdown stops the worker after its current job. If ctx expires first the job is interrupted and
+// checkpointed back to the queue, so that it runs again after the next start.
+func Shutdown(ctx context.Context) error {
+	close(stopping)
+
+	select {
+	case <-workerDone:
+		return nil
+	case <-ctx.Done():
+	}
+
+	runningMu.Lock()
+	for _, cancel := range running {
+		cancel(errShutdown)
+	}
+	runningMu.Unlock()
+
+	// ffmpeg gets config.TranscodeKillGrace to exit before it is killed
+	<-workerDone
+	return ctx.Err()
+}
+
+// interrupted records a job whose context was cancelled and reports whether it was. Jobs interrupted by
+// Shutdown are queued again without counting the attempt, others were cancelled through Cancel.
+func interrupted(ctx context.Context, job db.Job) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+
+	if errors.Is(context.Cause(ctx), errShutdown) {
+		jobLogger(job).Info("job interrupted by shutdown, it is queued again")
+		job.Status, job.Attempts, job.Error = db.JobQueued, job.Attempts-1, ""
+		metrics.TranscodeJobs.WithLabelValues(job.Type, "interrupted").Inc()
+	} else {
+		jobLogger(job).Info("job cancelled")
+		job.Status, job.Error, job.Finished = db.JobCancelled, "cancelled", time.Now().UTC()
+		metrics.TranscodeJobs.WithLabelValues(job.Type, "cancelled").Inc()
+	}
+	putJob(job)
+	return true
+}
+
+// ResumeJobs queues the jobs left over by the previous run: queued jobs, including those waiting for
+// a retry, and jobs that were running when the process died
+func ResumeJobs() error {
+	var jobs []db.Job
+	err := db.Default.ForEachJob(func(job db.Job) bool {
+		if job.Status == db.JobQueued || job.Status == db.JobRunning {
+			jobs = append(jobs, job)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.Status == db.JobRunning {
+			job.Status = db.JobQueued
+			putJob(job)
+		}
+
+		// requeue checks the stored job, which is unchanged until the job runs
+		if delay := time.Until(job.NextAttempt); delay > 0 {
+			time.AfterFunc(delay, func() { requeue(job) })
+		} else {
+			go requeue(job)
+		}
+	}
+
+	if len(jobs) > 0 {
+		slog.Info("resumed transcode jobs", "count", len(jobs))
+	}
+	return nil
+}
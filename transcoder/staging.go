@@ -0,0 +1,210 @@
+package transcoder
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/metrics"
+)
+
+// encodeStaged runs run.sh into a directory of config.StagingDir under a timeout scaled to the input duration, checks that the HLS output is
+// complete and only then renames it to outDir, replacing any previous output there. The staging
+// directory is removed when the encode fails, so a broken master.m3u8 is never served. onProgress, if not nil,
+// is called with the whole percentage of the input encoded so far.
+func encodeStaged(ctx context.Context, input, outDir, stageName string, onProgress func(percent float64)) error {
+	stage := filepath.Join(config.StagingDir, stageName)
+	if err := os.RemoveAll(stage); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stage, os.ModePerm); err != nil {
+		return err
+	}
+
+	duration, err := probeDuration(ctx, input)
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to probe duration, using the maximum timeout", "input", input, "error", err)
+	}
+	timeout := jobTimeout(duration)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	onTime := percentReporter(duration, onProgress)
+	if err := RunFFmpegScript(ctx, "./run.sh", input, stage, "master", 4, 25, 100, "veryfast", "640x360", "1280x720", "1920x1080", onTime); err != nil {
+		os.RemoveAll(stage)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %s: %w", ErrTimeout, timeout, err)
+		}
+		return fmt.Errorf("transcoding error: %w", err)
+	}
+
+	if err := validateHLS(stage); err != nil {
+		os.RemoveAll(stage)
+		return fmt.Errorf("incomplete HLS output: %w", err)
+	}
+	observeEncode(filepath.Join(stage, "master.m3u8"), duration, time.Since(start))
+
+	if err := os.MkdirAll(filepath.Dir(outDir), os.ModePerm); err != nil {
+		os.RemoveAll(stage)
+		return err
+	}
+	if err := replaceDir(stage, outDir); err != nil {
+		os.RemoveAll(stage)
+		return err
+	}
+
+	return nil
+}
+
+// replaceDir renames src, a directory of config.StagingDir, to dst. A previous dst is first renamed aside
+// next to src and only removed once src is in place, so dst is never left half removed, and it is
+// restored if src cannot be moved in.
+func replaceDir(src, dst string) error {
+	aside := src + ".replaced"
+	if err := os.RemoveAll(aside); err != nil {
+		return err
+	}
+
+	err := os.Rename(dst, aside)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	replaced := err == nil
+
+	if err := os.Rename(src, dst); err != nil {
+		if replaced {
+			os.Rename(aside, dst)
+		}
+		return err
+	}
+
+	// The new output is live, a previous one that cannot be removed now is cleared with the staging
+	// directory on the next start
+	os.RemoveAll(aside)
+	return nil
+}
+
+// validateHLS checks that the master playlist of dir lists at least one variant, and that every
+// variant playlist is finished and all of its segments exist and are not empty
+func validateHLS(dir string) error {
+	variants, err := playlistURIs(filepath.Join(dir, "master.m3u8"))
+	if err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return errors.New("master playlist lists no variants")
+	}
+
+	for _, variant := range variants {
+		variantPath := filepath.Join(dir, variant)
+		segments, err := playlistURIs(variantPath)
+		if err != nil {
+			return err
+		}
+		if len(segments) == 0 {
+			return fmt.Errorf("%s lists no segments", variant)
+		}
+
+		for _, segment := range segments {
+			info, err := os.Stat(filepath.Join(filepath.Dir(variantPath), segment))
+			if err != nil {
+				return err
+			}
+			if info.Size() == 0 {
+				return fmt.Errorf("segment %s is empty", segment)
+			}
+		}
+	}
+
+	return nil
+}
+
+// playlistURIs returns the URI lines of a playlist. Variant playlists must end with #EXT-X-ENDLIST,
+// which ffmpeg only writes once the encode is complete.
+func playlistURIs(playlistPath string) ([]string, error) {
+	file, err := os.Open(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var uris []string
+	isMedia, ended := false, false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF"):
+			isMedia = true
+		case line == "#EXT-X-ENDLIST":
+			ended = true
+		case !strings.HasPrefix(line, "#"):
+			uris = append(uris, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if isMedia && !ended {
+		return nil, fmt.Errorf("%s is not finished", filepath.Base(playlistPath))
+	}
+	return uris, nil
+}
+
+// clearStaging removes everything left in config.StagingDir
+func clearStaging() error {
+	entries, err := os.ReadDir(config.StagingDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		errs = append(errs, os.RemoveAll(filepath.Join(config.StagingDir, entry.Name())))
+	}
+	return errors.Join(errs...)
+}
+
+// observeEncode records the wall time and realtime factor of a successful encode for each rendition
+// listed in its master playlist
+func observeEncode(masterPath string, duration, elapsed time.Duration) {
+	file, err := os.Open(masterPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			continue
+		}
+
+		rendition := "unknown"
+		for _, attribute := range strings.Split(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"), ",") {
+			if value, ok := strings.CutPrefix(attribute, "RESOLUTION="); ok {
+				rendition = value
+			}
+		}
+
+		metrics.TranscodeDuration.WithLabelValues(rendition).Observe(elapsed.Seconds())
+		if duration > 0 {
+			metrics.TranscodeRealtimeFactor.WithLabelValues(rendition).Observe(duration.Seconds() / elapsed.Seconds())
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package transcoder
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReplaceDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous bool
+	}{
+		{"first output", false},
+		{"replaces previous output", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			src, dst := filepath.Join(root, "stage"), filepath.Join(root, "hls", "id")
+			writeFile(t, filepath.Join(src, "master.m3u8"), "new")
+			if tt.previous {
+				writeFile(t, filepath.Join(dst, "master.m3u8"), "old")
+				writeFile(t, filepath.Join(dst, "stale.ts"), "old")
+			} else if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := replaceDir(src, dst); err != nil {
+				t.Fatalf("replaceDir: %v", err)
+			}
+
+			if data, err := os.ReadFile(filepath.Join(dst, "master.m3u8")); err != nil || string(data) != "new" {
+				t.Errorf("master.m3u8 = %q, %v, want the new output", data, err)
+			}
+			for _, gone := range []string{src, src + ".replaced", filepath.Join(dst, "stale.ts")} {
+				if _, err := os.Stat(gone); !os.IsNotExist(err) {
+					t.Errorf("%s still exists", gone)
+				}
+			}
+		})
+	}
+}
+
+func TestReplaceDirKeepsPreviousOnFailure(t *testing.T) {
+	root := t.TempDir()
+	dst := filepath.Join(root, "hls", "id")
+	writeFile(t, filepath.Join(dst, "master.m3u8"), "old")
+
+	// A missing source cannot be renamed into place
+	if err := replaceDir(filepath.Join(root, "missing"), dst); err == nil {
+		t.Fatal("replaceDir succeeded without a source")
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dst, "master.m3u8")); err != nil || string(data) != "old" {
+		t.Errorf("master.m3u8 = %q, %v, want the previous output back", data, err)
+	}
+}
+
+// writeFile creates the file at path with its parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateHLS(t *testing.T) {
+	const (
+		master  = "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1,RESOLUTION=1280x720\n720p.m3u8\n"
+		variant = "#EXTM3U\n#EXTINF:4.0,\n720p_000.ts\n#EXTINF:2.5,\n720p_001.ts\n#EXT-X-ENDLIST\n"
+	)
+	complete := map[string]string{
+		"master.m3u8": master,
+		"720p.m3u8":   variant,
+		"720p_000.ts": "segment",
+		"720p_001.ts": "segment",
+	}
+
+	tests := []struct {
+		name    string
+		changes map[string]string
+		// missing lists the files of the complete output to leave out
+		missing []string
+		wantErr bool
+	}{
+		{name: "complete output"},
+		{
+			name: "several variants",
+			changes: map[string]string{
+				"master.m3u8":     master + "#EXT-X-STREAM-INF:BANDWIDTH=2,RESOLUTION=1920x1080\nv1/1080p.m3u8\n",
+				"v1/1080p.m3u8":   "#EXTM3U\n#EXTINF:4.0,\n1080p_000.ts\n#EXT-X-ENDLIST\n",
+				"v1/1080p_000.ts": "segment",
+			},
+		},
+		{name: "no master playlist", missing: []string{"master.m3u8"}, wantErr: true},
+		{name: "no variants", changes: map[string]string{"master.m3u8": "#EXTM3U\n"}, wantErr: true},
+		{name: "missing variant playlist", missing: []string{"720p.m3u8"}, wantErr: true},
+		{name: "unfinished variant", changes: map[string]string{"720p.m3u8": "#EXTM3U\n#EXTINF:4.0,\n720p_000.ts\n"}, wantErr: true},
+		{name: "variant without segments", changes: map[string]string{"720p.m3u8": "#EXTM3U\n#EXT-X-ENDLIST\n"}, wantErr: true},
+		{name: "missing segment", missing: []string{"720p_001.ts"}, wantErr: true},
+		{name: "empty segment", changes: map[string]string{"720p_000.ts": ""}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range complete {
+				if content, ok := tt.changes[name]; ok {
+					writeFile(t, filepath.Join(dir, name), content)
+					continue
+				}
+				if !slices.Contains(tt.missing, name) {
+					writeFile(t, filepath.Join(dir, name), content)
+				}
+			}
+			for name, content := range tt.changes {
+				if _, ok := complete[name]; !ok {
+					writeFile(t, filepath.Join(dir, name), content)
+				}
+			}
+
+			if err := validateHLS(dir); (err != nil) != tt.wantErr {
+				t.Errorf("validateHLS = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
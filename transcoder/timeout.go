@@ -0,0 +1,43 @@
+package transcoder
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+)
+
+// probeTimeout bounds the ffprobe run reading the duration of a source video
+const probeTimeout = 30 * time.Second
+
+// jobTimeout returns how long the encode of a source of the given duration may run, config.TranscodeTimeoutFactor
+// times its duration clamped to config.TranscodeTimeoutMin and config.TranscodeTimeoutMax. An unknown
+// duration, 0, gets config.TranscodeTimeoutMax.
+func jobTimeout(duration time.Duration) time.Duration {
+	if duration <= 0 {
+		return config.TranscodeTimeoutMax
+	}
+
+	timeout := time.Duration(float64(duration) * config.TranscodeTimeoutFactor)
+	return min(max(timeout, config.TranscodeTimeoutMin), config.TranscodeTimeoutMax)
+}
+
+// probeDuration reads the duration of a media file with ffprobe
+func probeDuration(ctx context.Context, input string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", input).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -1,39 +1,107 @@
 package transcoder
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/metrics"
+	"github.com/LinuxSploit/TusAce/progress"
+	"github.com/LinuxSploit/TusAce/storage"
 	"github.com/LinuxSploit/TusAce/utils"
+	"github.com/LinuxSploit/TusAce/webhook"
 )
 
 // Queue for storing upload IDs
 var TranscodeQueue = make(chan string, 100)
 
-// TranscodePipeline performs video transcoding and manages temporary files
-func TranscodePipeline(id string, input_path, output_path string) error {
+// RetranscodeQueue holds the ids of existing videos to encode again from their retained original
+var RetranscodeQueue = make(chan string, 100)
+
+// running holds the cancel functions of the transcodes in progress, by upload id
+var (
+	runningMu sync.Mutex
+	running   = map[string]context.CancelCauseFunc{}
+)
+
+// Enqueue records a queued transcode job for the upload and adds it to the queue
+func Enqueue(id string) {
+	if err := db.Default.PutJob(db.Job{UploadID: id, Type: db.JobTranscode, Status: db.JobQueued}); err != nil {
+		slog.Error("failed to record transcode job", "upload_id", id, "job", db.JobTranscode, "error", err)
+	}
+	send(TranscodeQueue, id)
+}
+
+// Cancel stops the jobs of the upload: a running job is killed and queued jobs are marked cancelled,
+// so that the worker skips them. It reports whether there was a job to cancel.
+func Cancel(id string) bool {
+	runningMu.Lock()
+	cancel, ok := running[id]
+	if ok {
+		cancel(nil)
+	}
+	runningMu.Unlock()
+
+	jobs, _ := db.Default.Jobs(id)
+	for _, job := range jobs {
+		if job.Status == db.JobQueued {
+			job.Status, job.Error, job.Finished = db.JobCancelled, "cancelled", time.Now().UTC()
+			putJob(job)
+			ok = true
+		}
+	}
+	return ok
+}
 
-	scriptPath := "./run.sh"
-	if err := RunFFmpegScript(scriptPath, input_path+id, output_path+id, "master", 4, 25, 100, "veryfast", "640x360", "1280x720", "1920x1080"); err != nil {
-		return fmt.Errorf("transcoding error: %w", err)
+// TranscodePipeline performs video transcoding and manages temporary files
+func TranscodePipeline(ctx context.Context, id string, input_path, output_path string) error {
+	// The script reads the upload from input_path
+	if err := storage.Default.Fetch(ctx, db.KindVideo, id); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %w", ErrMissingInput, err)
+		}
+		return fmt.Errorf("failed to fetch upload: %w", err)
 	}
 
-	if err := os.Remove(input_path + id); err != nil {
-		return fmt.Errorf("failed to remove temporary uploads: %w", err)
+	onProgress := func(percent float64) {
+		progress.PublishUpload(id, progress.Update{Stage: progress.StageProcessing, Percent: percent})
+	}
+	if err := encodeStaged(ctx, input_path+id, output_path+id, id, onProgress); err != nil {
+		return err
+	}
+	if err := storage.Default.Publish(ctx, storage.HLS, id); err != nil {
+		return fmt.Errorf("failed to publish HLS output: %w", err)
 	}
 
-	if err := os.Remove(input_path + id + ".info"); err != nil {
-		return fmt.Errorf("failed to remove temporary uploads: %w", err)
+	// Delete, keep or archive the original depending on config.OriginalRetention
+	if err := RetainOriginal(id, input_path); err != nil {
+		return fmt.Errorf("failed to retain original: %w", err)
+	}
+	if err := storage.Default.RemoveUpload(ctx, db.KindVideo, id); err != nil {
+		return fmt.Errorf("failed to remove upload from storage: %w", err)
 	}
 
 	return nil
 }
 
-// RunFFmpegScript executes an external Bash script with the specified parameters, thumbnailFrame is percentage from 0 to 100
-func RunFFmpegScript(scriptPath, videoIn, out_dir, videoOut string, hlsTime, fps, gopSize int, presetP, vSize3, vSize5, vSize6 string) error {
-	cmd := exec.Command("/bin/bash", scriptPath,
+// RunFFmpegScript executes an external Bash script with the specified parameters, thumbnailFrame is percentage from 0 to 100.
+// Cancelling ctx stops the script together with the ffmpeg processes it started: they get SIGTERM first,
+// and SIGKILL if they are still running after config.TranscodeKillGrace. onTime, if not nil, is called
+// with the position ffmpeg reports having encoded.
+func RunFFmpegScript(ctx context.Context, scriptPath, videoIn, out_dir, videoOut string, hlsTime, fps, gopSize int, presetP, vSize3, vSize5, vSize6 string, onTime func(time.Duration)) error {
+	cmd := exec.CommandContext(ctx, "/bin/bash", scriptPath,
 		videoIn,
 		videoOut,
 		fmt.Sprintf("%d", hlsTime),
@@ -47,33 +115,206 @@ func RunFFmpegScript(scriptPath, videoIn, out_dir, videoOut string, hlsTime, fps
 		"50",
 	)
 
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to run FFmpeg script: %w", err)
+	// Run the script in its own process group so ffmpeg is stopped along with bash
+	var kill *time.Timer
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := -cmd.Process.Pid
+		kill = time.AfterFunc(config.TranscodeKillGrace, func() { syscall.Kill(pgid, syscall.SIGKILL) })
+		return syscall.Kill(pgid, syscall.SIGTERM)
 	}
+	// Stop waiting for the output pipes if a killed process left them open
+	cmd.WaitDelay = 2 * config.TranscodeKillGrace
 
-	fmt.Println(cmd.String())
+	// Keep the end of the output, it explains why a failed job failed. The output goes to stderr,
+	// stdout only carries the JSON logs.
+	stderr := &tailBuffer{limit: stderrTailSize}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	cmd.Stdout = io.MultiWriter(os.Stderr, stderr)
+	if onTime != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, &progressWriter{onTime: onTime})
+	}
+	err := cmd.Run()
+	if kill != nil {
+		kill.Stop()
+	}
+	if err != nil {
+		return classify(&ScriptError{Err: err, StderrTail: stderr.String()})
+	}
+
+	logging.FromContext(ctx).Debug("ffmpeg script finished", "command", cmd.String())
 
 	return nil
 }
 
-// startTranscodeWorker processes files from the queue one by one
+// startTranscodeWorker processes files from the queues one by one
 func StartTranscodeWorker(input_path, output_path string) {
-	go func() {
+	// Nothing is encoding yet, whatever is left in staging comes from an interrupted run
+	if err := clearStaging(); err != nil {
+		slog.Error("failed to clear staging directory", "error", err)
+	}
 
-		for id := range TranscodeQueue {
-			log.Printf("####### ==> Starting transcoding for upload: %s\n", id)
-			if err := TranscodePipeline(id, input_path, output_path); err != nil {
-				log.Printf("Error during transcoding uploaded file - %s: %v", id, err)
-			} else {
-				log.Printf("Successfully transcoded uploaded file: %s", id)
-
-				err := utils.ResizeAndConvertToWebP("/storage/tus/hls/"+id+"/thumbnail.jpg", "/storage/tus/thumbnail/"+id+"-500w.webp", 500)
-				if err != nil {
-					fmt.Println("covert err: ", err)
-				}
+	go func() {
+		defer close(workerDone)
+		for {
+			select {
+			case <-stopping:
+				return
+			case id := <-TranscodeQueue:
+				processJob(id, input_path, output_path)
+			case id := <-RetranscodeQueue:
+				processRetranscode(id, output_path)
 			}
 		}
 	}()
 }
+
+// track makes a running job cancellable through Cancel, the returned function must be called once it is done.
+// The context carries the upload id into the logs of the job.
+func track(id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(logging.WithUploadID(context.Background(), id))
+	runningMu.Lock()
+	running[id] = cancel
+	runningMu.Unlock()
+
+	return ctx, func() {
+		runningMu.Lock()
+		delete(running, id)
+		runningMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// startJob records that a job of the given type starts running for the upload
+func startJob(id, jobType string) db.Job {
+	job, err := db.Default.GetJob(id, jobType)
+	if err != nil {
+		job = db.Job{UploadID: id, Type: jobType}
+	}
+	job.Status = db.JobRunning
+	job.Attempts++
+	job.Started = time.Now().UTC()
+	job.Error = ""
+	putJob(job)
+	return job
+}
+
+// processJob transcodes one upload, records the job, its derivatives and the upload status in the database
+func processJob(id, input_path, output_path string) {
+	logger := slog.With("upload_id", id, "job", db.JobTranscode)
+
+	// Uploads deleted while waiting in the queue are not transcoded
+	if upload, err := db.Default.GetUpload(id); err == nil && !upload.Deleted.IsZero() {
+		logger.Info("skipping transcoding of deleted upload")
+		putJob(db.Job{UploadID: id, Type: db.JobTranscode, Status: db.JobCancelled, Finished: time.Now().UTC()})
+		return
+	}
+	// Neither are cancelled jobs, nor duplicates of a job that already ran
+	if job, err := db.Default.GetJob(id, db.JobTranscode); err == nil && job.Status != db.JobQueued {
+		logger.Info("skipping transcoding, the job is no longer queued", "status", job.Status)
+		return
+	}
+
+	logger.Info("starting transcoding")
+
+	ctx, done := track(id)
+	defer done()
+
+	job := startJob(id, db.JobTranscode)
+	progress.PublishUpload(id, progress.Update{Stage: progress.StageProcessing})
+
+	if err := TranscodePipeline(ctx, id, input_path, output_path); err != nil {
+		if interrupted(ctx, job) {
+			return
+		}
+
+		logger.Error("transcoding failed", "error", err)
+		if !retryOrDeadLetter(&job, err) {
+			if err := db.Default.Fail(id, job.Error); err != nil {
+				logger.Error("failed to record upload failure", "error", err)
+			}
+			webhook.PublishUpload(webhook.EventTranscodeFailed, id, &job)
+			progress.PublishUpload(id, progress.Update{Stage: progress.StageFailed, Error: job.Error})
+		}
+		return
+	}
+
+	logger.Info("transcoding succeeded")
+	job.Status, job.Finished = db.JobSucceeded, time.Now().UTC()
+	putJob(job)
+	metrics.TranscodeJobs.WithLabelValues(job.Type, "succeeded").Inc()
+
+	hlsDir := output_path + id
+	recordHLS(id, hlsDir)
+	recordPosters(id, hlsDir+"/thumbnail.jpg")
+	setStatus(id, db.StatusReady)
+	webhook.PublishUpload(webhook.EventTranscodeSucceeded, id, &job)
+	progress.PublishUpload(id, progress.Update{Stage: progress.StageReady, Percent: 100})
+}
+
+// recordHLS records the HLS output directory of an upload as its HLS derivative
+func recordHLS(id, hlsDir string) {
+	if err := db.Default.PutDerivatives(id, db.DerivativeHLS, []db.Derivative{{Kind: db.DerivativeHLS, Name: "master", Path: hlsDir, Size: utils.DirSize(hlsDir)}}); err != nil {
+		slog.Error("failed to record HLS output", "upload_id", id, "error", err)
+	}
+}
+
+// recordPosters generates the poster variants from the frame extracted by run.sh, publishes them and records
+// them with their placeholder
+func recordPosters(id, thumbnailPath string) {
+	files, placeholder, err := utils.GenerateImageVariants(thumbnailPath, config.ThumbnailDir, id, utils.ImageVariants)
+	if err != nil {
+		slog.Error("failed to generate posters", "upload_id", id, "error", err)
+		return
+	}
+	for _, file := range files {
+		if err := storage.Default.Publish(context.Background(), storage.Thumbnail, filepath.Base(file.Path)); err != nil {
+			slog.Error("failed to publish poster", "upload_id", id, "error", err)
+			return
+		}
+	}
+
+	if err := db.Default.PutDerivatives(id, db.DerivativeThumbnail, ThumbnailDerivatives(files)); err != nil {
+		slog.Error("failed to record posters", "upload_id", id, "error", err)
+	}
+	if err := db.Default.MergeMetaData(id, placeholder.MetaData()); err != nil {
+		slog.Error("failed to record poster placeholder", "upload_id", id, "error", err)
+	}
+	progress.PublishUpload(id, progress.Update{Stage: progress.StageThumbnail, Percent: 100})
+}
+
+// ThumbnailDerivatives converts the WebP variants generated for an upload, the variants of an image or the
+// posters of a video, into its thumbnail derivatives
+func ThumbnailDerivatives(files []utils.VariantFile) []db.Derivative {
+	derivatives := make([]db.Derivative, 0, len(files))
+	for _, file := range files {
+		derivatives = append(derivatives, db.Derivative{
+			Kind:   db.DerivativeThumbnail,
+			Name:   file.Variant.Name,
+			Path:   file.Path,
+			Size:   file.Size,
+			Width:  file.Width,
+			Height: file.Height,
+		})
+	}
+	return derivatives
+}
+
+// putJob records the state of a transcode job, logging failures
+func putJob(job db.Job) {
+	if err := db.Default.PutJob(job); err != nil {
+		jobLogger(job).Error("failed to record transcode job", "error", err)
+	}
+}
+
+// jobLogger returns the default logger annotated with the upload and type of a job
+func jobLogger(job db.Job) *slog.Logger {
+	return slog.With("upload_id", job.UploadID, "job", job.Type)
+}
+
+// setStatus records the processing status of an upload, logging failures
+func setStatus(id, status string) {
+	if err := db.Default.SetStatus(id, status); err != nil {
+		slog.Error("failed to record upload status", "upload_id", id, "status", status, "error", err)
+	}
+}
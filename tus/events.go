@@ -0,0 +1,168 @@
+package tus
+
+import (
+	"cmp"
+	"errors"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/events"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/media"
+	"github.com/LinuxSploit/TusAce/metrics"
+	"github.com/LinuxSploit/TusAce/progress"
+	"github.com/LinuxSploit/TusAce/transcoder"
+	"github.com/LinuxSploit/TusAce/webhook"
+)
+
+// SubscribeUploadEvents registers the subscribers that act on the notifications of both tus handlers.
+// It must be called once, before the handlers are set up.
+func SubscribeUploadEvents() {
+	events.Subscribe("log", logEvent)
+	events.Subscribe("metrics", countEvent, events.Created, events.Completed)
+	events.Subscribe("index", indexEvent, events.Created, events.Completed, events.Terminated)
+	events.Subscribe("webhooks", publishEvent, events.Created, events.Completed)
+	events.Subscribe("transcoder", transcodeEvent, events.Completed)
+	events.Subscribe("progress", progressEvent)
+}
+
+// logEvent logs every event, progress only at debug level
+func logEvent(event events.Event) {
+	upload := event.Hook.Upload
+	logger := logging.FromContext(event.Hook.Context).With("upload_id", upload.ID, "kind", event.Kind)
+
+	switch event.Type {
+	case events.Created:
+		logger.Info("upload created", "filetype", upload.MetaData["filetype"], "size", upload.Size)
+	case events.Completed:
+		logger.Info("upload completed", "size", upload.Size)
+	case events.Progress:
+		logger.Debug("upload progress", "offset", upload.Offset, "size", upload.Size)
+	case events.Terminated:
+		logger.Info("upload terminated")
+	}
+}
+
+// countEvent feeds the upload metrics
+func countEvent(event events.Event) {
+	switch event.Type {
+	case events.Created:
+		metrics.UploadsCreated.WithLabelValues(event.Kind).Inc()
+	case events.Completed:
+		metrics.UploadsCompleted.WithLabelValues(event.Kind).Inc()
+		metrics.UploadBytesCompleted.WithLabelValues(event.Kind).Add(float64(event.Hook.Upload.Size))
+	}
+}
+
+// indexEvent keeps the database in sync with the uploads created, completed and terminated through tus
+func indexEvent(event events.Event) {
+	id := event.Hook.Upload.ID
+	logger := logging.FromContext(event.Hook.Context).With("upload_id", id)
+
+	switch event.Type {
+	case events.Created:
+		// The pre-finish hook of an upload sent in its creation request may have recorded it already
+		if err := db.Default.AddUpload(media.UploadRecord(event.Kind, event.Hook.Upload)); err != nil {
+			logger.Error("failed to record upload", "error", err)
+		}
+	case events.Completed:
+		// Images are already marked ready or failed by the pre-finish hook
+		err := db.Default.UpdateUpload(id, func(upload *db.Upload) {
+			if upload.Status == db.StatusUploading {
+				upload.Status = db.StatusProcessing
+			}
+		})
+		if errors.Is(err, db.ErrNotFound) {
+			err = db.Default.AddUpload(media.UploadRecord(event.Kind, event.Hook.Upload))
+		}
+		if err != nil {
+			logger.Error("failed to record upload completion", "error", err)
+		}
+	case events.Terminated:
+		// The owner terminated the upload, see TerminationMiddleware. tusd removed the upload data, the rest
+		// is deleted like through the media API and purged once the grace period passed.
+		if _, err := media.Delete(id); err != nil && !errors.Is(err, db.ErrNotFound) {
+			logger.Error("failed to delete terminated upload", "error", err)
+		}
+	}
+}
+
+// publishEvent sends the upload webhooks
+func publishEvent(event events.Event) {
+	switch event.Type {
+	case events.Created:
+		webhook.Publish(webhook.EventUploadCreated, media.UploadRecord(event.Kind, event.Hook.Upload), nil)
+	case events.Completed:
+		upload, err := db.Default.GetUpload(event.Hook.Upload.ID)
+		if err != nil {
+			upload = media.UploadRecord(event.Kind, event.Hook.Upload)
+		}
+		// The index subscriber may not have recorded the completion yet
+		if upload.Status == db.StatusUploading {
+			upload.Status = db.StatusProcessing
+		}
+		webhook.Publish(webhook.EventUploadCompleted, upload, nil)
+	}
+}
+
+// staleProgressWindow is how long the progress notifications of a completed upload are ignored. tusd
+// sends the last one once the request completing the upload has ended, after the completion.
+const staleProgressWindow = time.Minute
+
+// completedAt holds when the uploads of the last staleProgressWindow completed, by id. Only
+// progressEvent uses it, from the goroutine of its subscriber.
+var completedAt = map[string]time.Time{}
+
+// progressEvent streams the upload progress to the owner. Completed images were already processed by
+// the pre-finish hook, their outcome is read from the database.
+func progressEvent(event events.Event) {
+	upload := event.Hook.Upload
+	owner := upload.MetaData["owner"]
+	update := progress.Update{UploadID: upload.ID, Kind: event.Kind}
+
+	switch event.Type {
+	case events.Created, events.Progress:
+		// Progress arriving after the completion would move the upload back to uploading
+		if _, ok := completedAt[upload.ID]; ok {
+			return
+		}
+		update.Stage = progress.StageUploading
+		// The size of an upload with a deferred length is unknown until its last PATCH
+		if !upload.SizeIsDeferred && upload.Size > 0 {
+			update.Percent = 100 * float64(upload.Offset) / float64(upload.Size)
+		}
+	case events.Completed:
+		now := time.Now()
+		for id, completed := range completedAt {
+			if now.Sub(completed) > staleProgressWindow {
+				delete(completedAt, id)
+			}
+		}
+		completedAt[upload.ID] = now
+
+		if event.Kind == db.KindVideo {
+			update.Stage = progress.StageProcessing
+			break
+		}
+		record, err := db.Default.GetUpload(upload.ID)
+		if err != nil || record.Status != db.StatusReady {
+			update.Stage, update.Error = progress.StageFailed, cmp.Or(record.Error, "image processing failed")
+			break
+		}
+		progress.Publish(owner, progress.Update{UploadID: upload.ID, Kind: event.Kind, Stage: progress.StageThumbnail, Percent: 100})
+		update.Stage, update.Percent = progress.StageReady, 100
+	case events.Terminated:
+		delete(completedAt, upload.ID)
+		progress.Forget(owner, upload.ID)
+		return
+	}
+
+	progress.Publish(owner, update)
+}
+
+// transcodeEvent queues completed videos for transcoding
+func transcodeEvent(event events.Event) {
+	if event.Kind == db.KindVideo {
+		transcoder.Enqueue(event.Hook.Upload.ID)
+	}
+}
@@ -0,0 +1,73 @@
+package tus
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/media"
+)
+
+// ExpirationMiddleware adds the tus expiration extension on top of a tusd handler, which does not
+// implement it: the extension is advertised and responses for unfinished uploads carry Upload-Expires.
+// The janitor removes uploads once they expired.
+func ExpirationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&expirationWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// expirationWriter sets the expiration headers right before tusd writes the response status
+type expirationWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	wroteHeader bool
+}
+
+func (w *expirationWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.addExpirationHeaders(statusCode)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *expirationWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController, used by tusd for read deadlines, access to the original writer
+func (w *expirationWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// addExpirationHeaders advertises the extension and sets Upload-Expires while the upload is unfinished
+func (w *expirationWriter) addExpirationHeaders(statusCode int) {
+	header := w.Header()
+	if extensions := header.Get("Tus-Extension"); extensions != "" {
+		header.Set("Tus-Extension", extensions+",expiration")
+	}
+
+	offset, err := strconv.ParseInt(header.Get("Upload-Offset"), 10, 64)
+	hasOffset := err == nil
+
+	switch {
+	case w.r.Method == http.MethodPost && statusCode == http.StatusCreated:
+		// The upload was just created, unless it was completed by a creation-with-upload request
+		length, err := strconv.ParseInt(w.r.Header.Get("Upload-Length"), 10, 64)
+		if !hasOffset || err != nil || offset < length {
+			header.Set("Upload-Expires", time.Now().Add(config.IncompleteUploadTTL).UTC().Format(http.TimeFormat))
+		}
+	case (w.r.Method == http.MethodPatch || w.r.Method == http.MethodHead) && statusCode < 300 && hasOffset:
+		upload, err := db.Default.GetUpload(path.Base(w.r.URL.Path))
+		if err == nil && offset < upload.Size {
+			header.Set("Upload-Expires", media.UploadExpires(upload).UTC().Format(http.TimeFormat))
+		}
+	}
+}
@@ -1,17 +1,24 @@
 package tus
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"regexp"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/events"
+	"github.com/LinuxSploit/TusAce/logging"
+	"github.com/LinuxSploit/TusAce/media"
+	"github.com/LinuxSploit/TusAce/metrics"
 	"github.com/LinuxSploit/TusAce/middleware"
+	"github.com/LinuxSploit/TusAce/storage"
 	"github.com/LinuxSploit/TusAce/transcoder"
 	"github.com/LinuxSploit/TusAce/utils"
-	"github.com/tus/tusd/v2/pkg/filelocker"
-	"github.com/tus/tusd/v2/pkg/filestore"
 	"github.com/tus/tusd/v2/pkg/handler"
 )
 
@@ -20,6 +27,9 @@ var (
 		"image/png":  true,
 		"image/webp": true,
 		"image/jpeg": true,
+		"image/heic": true,
+		"image/heif": true,
+		"image/avif": true,
 	}
 
 	VideoFileTypes map[string]bool = map[string]bool{
@@ -31,37 +41,55 @@ var (
 	}
 )
 
+// ErrShuttingDown rejects the creation of uploads once StopAcceptingUploads was called
+var ErrShuttingDown = handler.NewError("ERR_SHUTTING_DOWN", "server is shutting down, retry later", http.StatusServiceUnavailable)
+
+// shuttingDown is set by StopAcceptingUploads
+var shuttingDown atomic.Bool
+
+// StopAcceptingUploads makes both handlers reject new uploads, uploads in progress can still be resumed
+func StopAcceptingUploads() {
+	shuttingDown.Store(true)
+}
+
+// uploadCors applies middleware.UploadCORS to the tusd handlers, which refuse the origins it does not allow
+func uploadCors() *handler.CorsConfig {
+	policy := middleware.UploadCORS
+	return &handler.CorsConfig{
+		AllowOrigin:      policy.OriginPattern(),
+		AllowCredentials: policy.Credentials,
+		AllowMethods:     policy.AllowMethodsHeader(),
+		AllowHeaders:     policy.AllowHeadersHeader(),
+		MaxAge:           policy.MaxAgeHeader(),
+		ExposeHeaders:    policy.ExposeHeadersHeader(),
+	}
+}
+
 // setupTusHandler initializes the tusd handler for managing uploads
-func SetupTusVideoHandler(basePath, storageDir string) (*handler.Handler, error) {
-	store := filestore.New(storageDir)
-	locker := filelocker.New(storageDir)
+func SetupTusVideoHandler(basePath string) (*handler.Handler, error) {
 	composer := handler.NewStoreComposer()
-	store.UseIn(composer)
-	locker.UseIn(composer)
+	storage.Default.UseIn(composer, db.KindVideo)
 
 	tusdHandler, err := handler.NewHandler(handler.Config{
-		BasePath:              basePath,
-		StoreComposer:         composer,
-		NotifyCompleteUploads: true,
-		NotifyUploadProgress:  true,
-		DisableDownload:       true,
-		MaxSize:               1024 * 1024 * 1024 * 5, // 5GB
-		Cors: &handler.CorsConfig{
-			Disable:          false,
-			AllowOrigin:      regexp.MustCompile(".*"),
-			AllowCredentials: false,
-			AllowMethods:     "POST, HEAD, PATCH, OPTIONS, GET, DELETE",
-			AllowHeaders:     "Authorization, x-email-address, Origin, X-Requested-With, X-Request-ID, X-HTTP-Method-Override, Content-Type, Upload-Length, Upload-Offset, Tus-Resumable, Upload-Metadata, Upload-Defer-Length, Upload-Concat, Upload-Incomplete, Upload-Complete, Upload-Draft-Interop-Version",
-			MaxAge:           "86400",
-			ExposeHeaders:    "Upload-Offset, Location, Upload-Length, Tus-Version, Tus-Resumable, Tus-Max-Size, Tus-Extension, Upload-Metadata, Upload-Defer-Length, Upload-Concat, Upload-Incomplete, Upload-Complete, Upload-Draft-Interop-Version",
-		},
+		BasePath:                basePath,
+		StoreComposer:           composer,
+		NotifyCompleteUploads:   true,
+		NotifyCreatedUploads:    true,
+		NotifyUploadProgress:    true,
+		NotifyTerminatedUploads: true,
+		DisableDownload:         true,
+		Logger:                  logging.TusdLogger(),
+		MaxSize:                 1024 * 1024 * 1024 * 5, // 5GB
+		Cors:                    uploadCors(),
 		PreUploadCreateCallback: func(hook handler.HookEvent) (handler.HTTPResponse, handler.FileInfoChanges, error) {
-			// Extract session token from the headers
-			sessionToken := hook.HTTPRequest.Header.Get("Authorization")
-			email := hook.HTTPRequest.Header.Get("x-email-address")
+			// No new uploads while shutting down, clients retry against the next instance
+			if shuttingDown.Load() {
+				return handler.HTTPResponse{}, handler.FileInfoChanges{}, ErrShuttingDown
+			}
 
-			// Validate the session token (e.g., check it against your auth service or database)
-			if sessionToken == "" || email == "" || middleware.ValidateSessionAndPerm(sessionToken, email) == 0 {
+			// Validate the session token against the auth service
+			email, ok := sessionEmail(hook.HTTPRequest.Header)
+			if !ok {
 				return handler.HTTPResponse{
 					StatusCode: http.StatusUnauthorized,
 					Body:       "Invalid or missing session token",
@@ -87,6 +115,7 @@ func SetupTusVideoHandler(basePath, storageDir string) (*handler.Handler, error)
 			// If the session token is valid, you can add additional metadata to the FileInfo if needed
 			newMeta := hook.Upload.MetaData
 			newMeta["createdDate"] = time.Now().UTC().Format(time.RFC3339) // Add CreatedDate
+			newMeta["owner"] = email                                       // Add Owner, the validated uploader
 
 			fileInfoChanges := handler.FileInfoChanges{
 				MetaData: newMeta,
@@ -95,64 +124,40 @@ func SetupTusVideoHandler(basePath, storageDir string) (*handler.Handler, error)
 			// No changes to the HTTP response in this case, just return a success
 			return handler.HTTPResponse{}, fileInfoChanges, nil
 		},
-		PreFinishResponseCallback: func(hook handler.HookEvent) (handler.HTTPResponse, error) {
-
-			fmt.Println("passing video into transcoding queue", hook.Upload.IsFinal)
-			transcoder.TranscodeQueue <- hook.Upload.ID // Add upload to queue for transcoding
-			return handler.HTTPResponse{}, nil
-		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create tusd handler: %w", err)
 	}
 
-	// Start a goroutine to handle completed uploads
-	go func() {
-		for event := range tusdHandler.CompleteUploads {
-			log.Printf("> Upload %s completed\n", event.Upload.ID)
-			// transcoder.TranscodeQueue <- event.Upload.ID // Add upload to queue for transcoding
-		}
-
-		//
-		for event := range tusdHandler.CreatedUploads {
-			log.Printf("> Upload %s Created\n", event.Upload.MetaData["filetype"])
-		}
-	}()
+	// Hand the upload notifications to the subscribers registered by SubscribeUploadEvents
+	events.Drain(tusdHandler, db.KindVideo)
 
 	return tusdHandler, nil
 }
 
 // setupTusHandler initializes the tusd handler for managing uploads
-func SetupTusImageHandler(basePath, storageDir string) (*handler.Handler, error) {
-	store := filestore.New(storageDir)
-	locker := filelocker.New(storageDir)
+func SetupTusImageHandler(basePath string) (*handler.Handler, error) {
 	composer := handler.NewStoreComposer()
-	store.UseIn(composer)
-	locker.UseIn(composer)
+	storage.Default.UseIn(composer, db.KindImage)
 
 	tusdHandler, err := handler.NewHandler(handler.Config{
-		BasePath:              basePath,
-		StoreComposer:         composer,
-		NotifyCompleteUploads: true,
-		NotifyUploadProgress:  true,
-		Cors: &handler.CorsConfig{
-			Disable:          false,
-			AllowOrigin:      regexp.MustCompile(".*"),
-			AllowCredentials: false,
-			AllowMethods:     "POST, HEAD, PATCH, OPTIONS, GET, DELETE",
-			AllowHeaders:     "Authorization, x-email-address, Origin, X-Requested-With, X-Request-ID, X-HTTP-Method-Override, Content-Type, Upload-Length, Upload-Offset, Tus-Resumable, Upload-Metadata, Upload-Defer-Length, Upload-Concat, Upload-Incomplete, Upload-Complete, Upload-Draft-Interop-Version",
-			MaxAge:           "86400",
-			ExposeHeaders:    "Upload-Offset, Location, Upload-Length, Tus-Version, Tus-Resumable, Tus-Max-Size, Tus-Extension, Upload-Metadata, Upload-Defer-Length, Upload-Concat, Upload-Incomplete, Upload-Complete, Upload-Draft-Interop-Version",
-		},
+		BasePath:                basePath,
+		StoreComposer:           composer,
+		NotifyCompleteUploads:   true,
+		NotifyCreatedUploads:    true,
+		NotifyUploadProgress:    true,
+		NotifyTerminatedUploads: true,
+		Logger:                  logging.TusdLogger(),
+		Cors:                    uploadCors(),
 		PreUploadCreateCallback: func(hook handler.HookEvent) (handler.HTTPResponse, handler.FileInfoChanges, error) {
-			// Extract session token from the headers
-			sessionToken := hook.HTTPRequest.Header.Get("Authorization")
-			email := hook.HTTPRequest.Header.Get("x-email-address")
-
-			fmt.Println(sessionToken, email)
+			// No new uploads while shutting down, clients retry against the next instance
+			if shuttingDown.Load() {
+				return handler.HTTPResponse{}, handler.FileInfoChanges{}, ErrShuttingDown
+			}
 
-			// Validate the session token (e.g., check it against your auth service or database)
-			if sessionToken == "" || email == "" || middleware.ValidateSessionAndPerm(sessionToken, email) == 0 {
+			// Validate the session token against the auth service
+			email, ok := sessionEmail(hook.HTTPRequest.Header)
+			if !ok {
 				return handler.HTTPResponse{
 					StatusCode: http.StatusUnauthorized,
 					Body:       "Invalid or missing session token",
@@ -169,7 +174,7 @@ func SetupTusImageHandler(basePath, storageDir string) (*handler.Handler, error)
 
 			isAllowed, ok := ImageFileTypes[fileType]
 			if !ok || !isAllowed {
-				fmt.Println(fileType)
+				logging.FromContext(hook.Context).Info("rejected image upload with invalid filetype", "filetype", fileType)
 				return handler.HTTPResponse{
 					StatusCode: http.StatusUnauthorized,
 					Body:       "Invalid filetype",
@@ -179,6 +184,7 @@ func SetupTusImageHandler(basePath, storageDir string) (*handler.Handler, error)
 			// If the session token is valid, you can add additional metadata to the FileInfo if needed
 			newMeta := hook.Upload.MetaData
 			newMeta["createdDate"] = time.Now().UTC().Format(time.RFC3339) // Add CreatedDate
+			newMeta["owner"] = email                                       // Add Owner, the validated uploader
 
 			fileInfoChanges := handler.FileInfoChanges{
 				MetaData: newMeta,
@@ -188,12 +194,38 @@ func SetupTusImageHandler(basePath, storageDir string) (*handler.Handler, error)
 			return handler.HTTPResponse{}, fileInfoChanges, nil
 		},
 		PreFinishResponseCallback: func(hook handler.HookEvent) (handler.HTTPResponse, error) {
+			logger := logging.FromContext(hook.Context).With("upload_id", hook.Upload.ID)
+			// tusd runs the hook before the notification of the creation is handled, the outcome needs a record
+			if err := db.Default.AddUpload(media.UploadRecord(db.KindImage, hook.Upload)); err != nil {
+				logger.Error("failed to record upload", "error", err)
+			}
 
-			fmt.Println("this is prefinish hook callback", "/storage/tus/images/"+hook.Upload.ID, "/storage/tus/thumbnail/"+hook.Upload.ID+"-500w.webp")
-			err := utils.ResizeAndConvertToWebP("/storage/tus/images/"+hook.Upload.ID, "/storage/tus/thumbnail/"+hook.Upload.ID+"-500w.webp", 500)
+			start := time.Now()
+			files, placeholder, err := processImage(hook)
+			outcome := "ok"
+			if err != nil {
+				outcome = "error"
+			}
+			metrics.ImageProcessingDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+			if errors.Is(err, utils.ErrImageTooLarge) {
+				failUpload(hook.Upload.ID, err)
+				// Surface the limit in the response to the final PATCH so the client sees why the upload failed
+				return handler.HTTPResponse{}, handler.NewError("ERR_IMAGE_TOO_LARGE", err.Error(), http.StatusUnprocessableEntity)
+			}
 			if err != nil {
-				fmt.Println("covert err: ", err)
+				logger.Error("failed to generate image variants", "error", err)
+				failUpload(hook.Upload.ID, err)
+				return handler.HTTPResponse{}, nil
+			}
+
+			// Keep the placeholder and variants with the upload so the media info API can return them
+			if err := db.Default.MergeMetaData(hook.Upload.ID, placeholder.MetaData()); err != nil {
+				logger.Error("failed to record placeholder", "error", err)
 			}
+			if err := db.Default.PutDerivatives(hook.Upload.ID, db.DerivativeThumbnail, transcoder.ThumbnailDerivatives(files)); err != nil {
+				logger.Error("failed to record variants", "error", err)
+			}
+			setStatus(hook.Upload.ID, db.StatusReady)
 			return handler.HTTPResponse{}, nil
 		},
 	})
@@ -201,17 +233,56 @@ func SetupTusImageHandler(basePath, storageDir string) (*handler.Handler, error)
 		return nil, fmt.Errorf("failed to create tusd handler: %w", err)
 	}
 
-	// Start a goroutine to handle completed uploads
-	go func() {
-		for event := range tusdHandler.CompleteUploads {
-			log.Printf("> Upload %s completed\n", event.Upload.ID)
-		}
+	// Hand the upload notifications to the subscribers registered by SubscribeUploadEvents
+	events.Drain(tusdHandler, db.KindImage)
+
+	return tusdHandler, nil
+}
+
+// processImage generates the variants of a finished image upload and publishes them
+func processImage(hook handler.HookEvent) ([]utils.VariantFile, utils.Placeholder, error) {
+	id := hook.Upload.ID
+	if err := storage.Default.Fetch(hook.Context, db.KindImage, id); err != nil {
+		return nil, utils.Placeholder{}, fmt.Errorf("failed to fetch upload: %w", err)
+	}
+
+	files, placeholder, err := utils.GenerateImageVariants(storage.UploadDir(db.KindImage)+id, config.ThumbnailDir, id, utils.ImageVariants)
+	if err != nil {
+		return nil, utils.Placeholder{}, err
+	}
 
-		//
-		for event := range tusdHandler.CreatedUploads {
-			log.Printf("> Upload %s Created\n", event.Upload.MetaData["filetype"])
+	for _, file := range files {
+		if err := storage.Default.Publish(hook.Context, storage.Thumbnail, filepath.Base(file.Path)); err != nil {
+			return nil, utils.Placeholder{}, err
 		}
-	}()
+	}
+	return files, placeholder, nil
+}
 
-	return tusdHandler, nil
+// validateSession checks a session with the auth service, see middleware.ValidateSessionAndPerm
+var validateSession = middleware.ValidateSessionAndPerm
+
+// sessionEmail returns the email of the uploader whose session the Authorization and x-email-address
+// headers carry, and whether the session is valid
+func sessionEmail(header http.Header) (string, bool) {
+	sessionToken := header.Get("Authorization")
+	email := header.Get("x-email-address")
+	if sessionToken == "" || email == "" || validateSession(sessionToken, email) == 0 {
+		return "", false
+	}
+	return email, true
+}
+
+// setStatus records the processing status of an upload, logging failures
+func setStatus(id, status string) {
+	if err := db.Default.SetStatus(id, status); err != nil {
+		slog.Error("failed to record upload status", "upload_id", id, "status", status, "error", err)
+	}
+}
+
+// failUpload records that the processing of an upload failed and why, logging failures
+func failUpload(id string, reason error) {
+	if err := db.Default.Fail(id, reason.Error()); err != nil {
+		slog.Error("failed to record upload failure", "upload_id", id, "reason", reason, "error", err)
+	}
 }
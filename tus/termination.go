@@ -0,0 +1,39 @@
+package tus
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/LinuxSploit/TusAce/db"
+)
+
+// TerminationMiddleware lets only the owner of an upload terminate it through a tus DELETE. tusd has no
+// hook running before a termination, so the request is checked before it reaches the handler. Other
+// callers get a 404, the way the media API answers for uploads of another owner.
+func TerminationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// tusd applies X-HTTP-Method-Override to POST requests
+		method := r.Method
+		if override := r.Header.Get("X-HTTP-Method-Override"); method == http.MethodPost && override != "" {
+			method = override
+		}
+		if method != http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		email, ok := sessionEmail(r.Header)
+		if !ok {
+			http.Error(w, "Invalid or missing session token", http.StatusUnauthorized)
+			return
+		}
+
+		upload, err := db.Default.GetUpload(path.Base(r.URL.Path))
+		if err != nil || upload.Owner != email || !upload.Deleted.IsZero() {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,175 @@
+package tus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+	"github.com/LinuxSploit/TusAce/events"
+	"github.com/LinuxSploit/TusAce/storage"
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// sessions are the sessions the fake auth service accepts, by token
+var sessions = map[string]string{"alice-token": "alice@example.com", "bob-token": "bob@example.com"}
+
+// useTestImageHandler serves the image handler behind TerminationMiddleware the way main does, with the
+// storage directories and the database in a temporary directory and a fake auth service
+func useTestImageHandler(t *testing.T) *httptest.Server {
+	t.Helper()
+	root := t.TempDir()
+	for dir, value := range map[*string]string{
+		&config.VideosDir:    "videos",
+		&config.ImagesDir:    "images",
+		&config.HLSDir:       "hls",
+		&config.ThumbnailDir: "thumbnail",
+	} {
+		previous := *dir
+		*dir = filepath.Join(root, value) + "/"
+		t.Cleanup(func() { *dir = previous })
+		if err := os.MkdirAll(*dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	database, err := db.Open(filepath.Join(root, "media.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousDB, previousBackend, previousValidate := db.Default, storage.Default, validateSession
+	db.Default = database
+	validateSession = func(sessionToken, email string) int {
+		if sessions[sessionToken] == email {
+			return 1
+		}
+		return 0
+	}
+	t.Cleanup(func() {
+		database.Close()
+		db.Default, storage.Default, validateSession = previousDB, previousBackend, previousValidate
+	})
+
+	backend, err := storage.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Default = backend
+
+	tusdHandler, err := SetupTusImageHandler("/image/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.StripPrefix("/image/", TerminationMiddleware(tusdHandler)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// request sends a tus request with the session of token, if any
+func request(t *testing.T, method, url, token string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+		req.Header.Set("x-email-address", sessions[token])
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestTerminationRequiresTheOwner(t *testing.T) {
+	server := useTestImageHandler(t)
+
+	// alice starts an upload, recorded the way the index subscriber does
+	resp := request(t, http.MethodPost, server.URL+"/image/", "alice-token", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "filetype aW1hZ2UvcG5n", // image/png
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creation answered %d", resp.StatusCode)
+	}
+	id := path.Base(resp.Header.Get("Location"))
+	if err := db.Default.PutUpload(db.Upload{ID: id, Kind: db.KindImage, Owner: "alice@example.com", Status: db.StatusUploading, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	uploadURL := server.URL + "/image/" + id
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		header     map[string]string
+		wantStatus int
+	}{
+		{"no session", http.MethodDelete, "", nil, http.StatusUnauthorized},
+		{"invalid session", http.MethodDelete, "", map[string]string{"Authorization": "alice-token", "x-email-address": "bob@example.com"}, http.StatusUnauthorized},
+		{"another user", http.MethodDelete, "bob-token", nil, http.StatusNotFound},
+		{"another user overriding the method", http.MethodPost, "bob-token", map[string]string{"X-HTTP-Method-Override": http.MethodDelete}, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if resp := request(t, test.method, uploadURL, test.token, test.header); resp.StatusCode != test.wantStatus {
+				t.Errorf("termination answered %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			if resp := request(t, http.MethodHead, uploadURL, "alice-token", nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("the upload is gone, HEAD answered %d", resp.StatusCode)
+			}
+			if _, err := os.Stat(config.ImagesDir + id + ".info"); err != nil {
+				t.Errorf("the upload is gone: %v", err)
+			}
+		})
+	}
+
+	if resp := request(t, http.MethodDelete, uploadURL, "alice-token", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("termination by the owner answered %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+	if resp := request(t, http.MethodHead, uploadURL, "alice-token", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of the terminated upload answered %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestTerminatedUploadIsSoftDeleted(t *testing.T) {
+	useTestImageHandler(t)
+
+	// The original is gone with the termination, the thumbnails stay until the grace period passed
+	thumbnail := config.ThumbnailDir + "abc-500w.webp"
+	if err := os.WriteFile(thumbnail, []byte("webp"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Default.PutUpload(db.Upload{ID: "abc", Kind: db.KindImage, Owner: "alice@example.com", Status: db.StatusReady, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	indexEvent(events.Event{Type: events.Terminated, Kind: db.KindImage, Hook: handler.HookEvent{
+		Context: context.Background(),
+		Upload:  handler.FileInfo{ID: "abc"},
+	}})
+
+	upload, err := db.Default.GetUpload("abc")
+	if err != nil {
+		t.Fatalf("the record of the terminated upload is gone: %v", err)
+	}
+	if upload.Deleted.IsZero() {
+		t.Error("the terminated upload is not marked deleted")
+	}
+	if _, err := os.Stat(thumbnail); err != nil {
+		t.Errorf("the thumbnail was removed before the grace period passed: %v", err)
+	}
+}
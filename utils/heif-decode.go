@@ -0,0 +1,247 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+)
+
+// heifBrands lists the ISO-BMFF major brands used by HEIC/HEIF (iPhone photos) and AVIF images
+var heifBrands = [][]byte{
+	[]byte("heic"), []byte("heix"), []byte("heim"), []byte("heis"),
+	[]byte("hevc"), []byte("hevx"), []byte("mif1"), []byte("msf1"),
+	[]byte("avif"), []byte("avis"),
+}
+
+// isHEIFContainer reports whether header starts with an ftyp box carrying a HEIF or AVIF brand
+func isHEIFContainer(header []byte) bool {
+	if len(header) < 12 || !bytes.Equal(header[4:8], []byte("ftyp")) {
+		return false
+	}
+
+	for _, brand := range heifBrands {
+		if bytes.Equal(header[8:12], brand) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxHEIFMetaSize bounds the meta box read to find the image dimensions, it holds the item
+// properties and locations only and stays far below this even for multi-image files
+const maxHEIFMetaSize = 4 << 20
+
+// decodeHEIF decodes a HEIC/HEIF or AVIF file by shelling out to libheif's heif-convert.
+// heif-convert applies the container's rotation and mirroring, so the returned image is
+// upright in the same way a decoded JPEG would be. The dimensions declared in the container are
+// checked against the limits first, and the decode memory is held while heif-convert runs.
+// The returned function releases the decode memory.
+func decodeHEIF(inputPath string) (image.Image, func(), error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	cfg, err := heifConfig(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read HEIF dimensions: %w", err)
+	}
+	release, err := reserveDecodeMemory(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := convertHEIF(inputPath)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return img, release, nil
+}
+
+// convertHEIF converts a HEIF file to PNG with heif-convert and decodes the result
+func convertHEIF(inputPath string) (image.Image, error) {
+	tmpDir, err := os.MkdirTemp("", "heif-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// PNG keeps the intermediate lossless, the output format is picked from the extension
+	pngPath := filepath.Join(tmpDir, "decoded.png")
+	cmd := exec.Command("heif-convert", inputPath, pngPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to decode HEIF image: %w: %s", err, bytes.TrimSpace(output))
+	}
+
+	file, err := os.Open(pngPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The container may declare a smaller image than the one decoded, check again before loading it
+	cfg, err := png.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImageConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return png.Decode(file)
+}
+
+// heifConfig reads the dimensions of a HEIF or AVIF image from the ispe properties of its meta box,
+// without decoding it. The largest image declared is reported, thumbnails and grid tiles are smaller.
+// The color model is 16 bits per channel when a pixi property declares more than 8 bits.
+// than the primary image.
+func heifConfig(r io.ReadSeeker) (image.Config, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return image.Config{}, err
+	}
+
+	// The meta box is a top-level box, usually right after ftyp
+	var meta []byte
+	for meta == nil {
+		boxType, size, err := readBoxHeader(r)
+		if err != nil {
+			return image.Config{}, err
+		}
+		if boxType != "meta" {
+			if size < 0 {
+				return image.Config{}, errors.New("no meta box")
+			}
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return image.Config{}, err
+			}
+			continue
+		}
+		if size < 0 || size > maxHEIFMetaSize {
+			return image.Config{}, fmt.Errorf("meta box of %d bytes", size)
+		}
+		meta = make([]byte, size)
+		if _, err := io.ReadFull(r, meta); err != nil {
+			return image.Config{}, err
+		}
+	}
+
+	// meta is a full box, its children follow the version and flags
+	if len(meta) < 4 {
+		return image.Config{}, errors.New("truncated meta box")
+	}
+	iprp := findBox(meta[4:], "iprp")
+	ipco := findBox(iprp, "ipco")
+
+	var cfg image.Config
+	for data := ipco; len(data) > 0; {
+		boxType, payload, rest, ok := nextBox(data)
+		if !ok {
+			break
+		}
+		data = rest
+
+		switch {
+		// ispe is a full box holding the width and height of the image it is associated with
+		case boxType == "ispe" && len(payload) >= 12:
+			width, height := int(binary.BigEndian.Uint32(payload[4:8])), int(binary.BigEndian.Uint32(payload[8:12]))
+			if int64(width)*int64(height) > int64(cfg.Width)*int64(cfg.Height) {
+				cfg.Width, cfg.Height = width, height
+			}
+		// pixi is a full box holding the number of channels and the bits per channel, heif-convert
+		// writes images above 8 bits as 16-bit PNG
+		case boxType == "pixi" && len(payload) >= 5:
+			channels := payload[5:min(len(payload), 5+int(payload[4]))]
+			if slices.ContainsFunc(channels, func(bits byte) bool { return bits > 8 }) {
+				cfg.ColorModel = color.NRGBA64Model
+			}
+		}
+	}
+
+	if cfg.Width == 0 {
+		return image.Config{}, errors.New("no image dimensions declared")
+	}
+	if cfg.ColorModel == nil {
+		cfg.ColorModel = color.NRGBAModel
+	}
+	return cfg, nil
+}
+
+// readBoxHeader reads the header of the ISO-BMFF box at the current position of r and returns its
+// type and the size of its payload, -1 if it extends to the end of the file
+func readBoxHeader(r io.Reader) (string, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", 0, err
+	}
+	size, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+
+	switch size {
+	case 0:
+		return string(header[4:8]), -1, nil
+	case 1:
+		var large [8]byte
+		if _, err := io.ReadFull(r, large[:]); err != nil {
+			return "", 0, err
+		}
+		size, headerSize = int64(binary.BigEndian.Uint64(large[:])), 16
+	}
+
+	if size < headerSize {
+		return "", 0, fmt.Errorf("invalid size %d of %q box", size, header[4:8])
+	}
+	return string(header[4:8]), size - headerSize, nil
+}
+
+// nextBox splits the first box off data, returning its type, its payload and the boxes after it
+func nextBox(data []byte) (boxType string, payload, rest []byte, ok bool) {
+	if len(data) < 8 {
+		return "", nil, nil, false
+	}
+	size := uint64(binary.BigEndian.Uint32(data[:4]))
+	boxType, headerSize := string(data[4:8]), uint64(8)
+
+	switch size {
+	case 0:
+		size = uint64(len(data))
+	case 1:
+		if len(data) < 16 {
+			return "", nil, nil, false
+		}
+		size, headerSize = binary.BigEndian.Uint64(data[8:16]), 16
+	}
+
+	if size < headerSize || size > uint64(len(data)) {
+		return "", nil, nil, false
+	}
+	return boxType, data[headerSize:size], data[size:], true
+}
+
+// findBox returns the payload of the first box of the given type among the boxes of data
+func findBox(data []byte, boxType string) []byte {
+	for len(data) > 0 {
+		found, payload, rest, ok := nextBox(data)
+		if !ok {
+			return nil
+		}
+		if found == boxType {
+			return payload
+		}
+		data = rest
+	}
+	return nil
+}
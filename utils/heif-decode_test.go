@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// box builds an ISO-BMFF box of the given type around payload
+func box(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	data := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(data, boxType...), body...)
+}
+
+// ispe builds an image spatial extents property
+func ispe(width, height uint32) []byte {
+	payload := binary.BigEndian.AppendUint32(make([]byte, 4), width)
+	return box("ispe", binary.BigEndian.AppendUint32(payload, height))
+}
+
+// pixi builds a pixel information property with the bits of each channel
+func pixi(bits ...byte) []byte {
+	return box("pixi", make([]byte, 4), []byte{byte(len(bits))}, bits)
+}
+
+// heifFile builds a HEIF file with a meta box holding the given item properties
+func heifFile(before []byte, properties ...[]byte) []byte {
+	ftyp := box("ftyp", []byte("heic"), make([]byte, 4), []byte("mif1heic"))
+	meta := box("meta", make([]byte, 4), box("hdlr", make([]byte, 24)), box("iprp", box("ipco", properties...)))
+	return bytes.Join([][]byte{ftyp, before, meta, box("mdat", make([]byte, 16))}, nil)
+}
+
+func TestHEIFConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want image.Config
+		// wantDeep tells whether the image decodes to 16 bits per channel
+		wantDeep bool
+		wantErr  bool
+	}{
+		{
+			name: "single image",
+			file: heifFile(nil, box("colr", make([]byte, 4)), ispe(4032, 3024)),
+			want: image.Config{Width: 4032, Height: 3024},
+		},
+		{
+			name: "primary image with grid tiles and a thumbnail",
+			file: heifFile(nil, ispe(512, 512), ispe(320, 240), ispe(8064, 6048), ispe(512, 512)),
+			want: image.Config{Width: 8064, Height: 6048},
+		},
+		{
+			name: "meta box after another top-level box",
+			file: heifFile(box("free", make([]byte, 100)), ispe(100, 50)),
+			want: image.Config{Width: 100, Height: 50},
+		},
+		{
+			name: "8 bits per channel",
+			file: heifFile(nil, pixi(8, 8, 8), ispe(4032, 3024)),
+			want: image.Config{Width: 4032, Height: 3024},
+		},
+		{
+			name:     "10 bits per channel",
+			file:     heifFile(nil, ispe(4032, 3024), pixi(10, 10, 10)),
+			want:     image.Config{Width: 4032, Height: 3024},
+			wantDeep: true,
+		},
+		{
+			name:     "12-bit alpha plane",
+			file:     heifFile(nil, ispe(4032, 3024), pixi(8, 8, 8), pixi(12)),
+			want:     image.Config{Width: 4032, Height: 3024},
+			wantDeep: true,
+		},
+		{
+			name:     "truncated pixi property",
+			file:     heifFile(nil, ispe(4032, 3024), box("pixi", make([]byte, 4), []byte{3, 10})),
+			want:     image.Config{Width: 4032, Height: 3024},
+			wantDeep: true,
+		},
+		{
+			name:    "no ispe property",
+			file:    heifFile(nil, box("colr", make([]byte, 4))),
+			wantErr: true,
+		},
+		{
+			name:    "no meta box",
+			file:    box("ftyp", []byte("heic"), make([]byte, 4)),
+			wantErr: true,
+		},
+		{
+			name:    "truncated box size",
+			file:    append(box("ftyp", []byte("heic")), 0, 0, 0, 4, 'm', 'e', 't', 'a'),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := heifConfig(bytes.NewReader(test.file))
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("heifConfig = %dx%d, want an error", cfg.Width, cfg.Height)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != test.want.Width || cfg.Height != test.want.Height {
+				t.Errorf("heifConfig = %dx%d, want %dx%d", cfg.Width, cfg.Height, test.want.Width, test.want.Height)
+			}
+			if deep := bytesPerPixel(cfg.ColorModel) == 8; deep != test.wantDeep {
+				t.Errorf("heifConfig 16 bits per channel = %v, want %v", deep, test.wantDeep)
+			}
+		})
+	}
+}
+
+func TestIsHEIFContainer(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   bool
+	}{
+		{"iPhone HEIC", []byte("\x00\x00\x00\x18ftypheic"), true},
+		{"HEIF image", []byte("\x00\x00\x00\x1cftypmif1"), true},
+		{"HEIF sequence", []byte("\x00\x00\x00\x1cftypmsf1"), true},
+		{"AVIF", []byte("\x00\x00\x00\x20ftypavif"), true},
+		{"AVIF sequence", []byte("\x00\x00\x00\x20ftypavis"), true},
+		{"MP4 video", []byte("\x00\x00\x00\x20ftypisom"), false},
+		{"QuickTime video", []byte("\x00\x00\x00\x14ftypqt  "), false},
+		{"JPEG", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01"), false},
+		{"WebP", []byte("RIFF\x00\x00\x00\x00WEBP"), false},
+		{"truncated", []byte("\x00\x00\x00\x18ftyphei"), false},
+		{"empty", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isHEIFContainer(test.header); got != test.want {
+				t.Errorf("isHEIFContainer(%q) = %v, want %v", test.header, got, test.want)
+			}
+		})
+	}
+}
+
+// fakeHEIFConvert puts a heif-convert on the PATH that writes output as its decoded image, or fails
+// when output is nil. It returns the file listing the calls made to it.
+func fakeHEIFConvert(t *testing.T, output image.Image) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\n"
+	if output != nil {
+		decoded := filepath.Join(dir, "decoded.png")
+		file, err := os.Create(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, output); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		script += "cp " + decoded + " \"$2\"\n"
+	} else {
+		script += "echo 'Could not read HEIF/AVIF file: Invalid input' >&2\nexit 1\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "heif-convert"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+func TestDecodeHEIF(t *testing.T) {
+	declared := func(width, height uint32) []byte { return heifFile(nil, ispe(width, height)) }
+	decoded := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	decoded.SetRGBA(10, 10, color.RGBA{255, 0, 0, 255})
+
+	tests := []struct {
+		name     string
+		file     []byte
+		output   image.Image
+		wantSize image.Point
+		// converted tells whether heif-convert is expected to run
+		converted    bool
+		wantErr      string
+		wantTooLarge bool
+	}{
+		{name: "decoded by heif-convert", file: declared(64, 48), output: decoded, wantSize: image.Pt(64, 48), converted: true},
+		{name: "declared too large", file: declared(20_000, 100), output: decoded, wantTooLarge: true},
+		{name: "declared too many pixels", file: declared(11_000, 11_000), output: decoded, wantTooLarge: true},
+		{name: "no dimensions declared", file: heifFile(nil), output: decoded, wantErr: "failed to read HEIF dimensions"},
+		{name: "decoded larger than declared", file: declared(64, 48), output: image.NewRGBA(image.Rect(0, 0, 13_000, 1)), converted: true, wantTooLarge: true},
+		{name: "heif-convert fails", file: declared(64, 48), converted: true, wantErr: "Invalid input"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := fakeHEIFConvert(t, test.output)
+			path := filepath.Join(t.TempDir(), "photo.heic")
+			if err := os.WriteFile(path, test.file, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// decodeImage recognises the container and hands it to decodeHEIF
+			img, release, err := decodeImage(path)
+			if _, statErr := os.Stat(calls); (statErr == nil) != test.converted {
+				t.Errorf("heif-convert ran = %v, want %v", statErr == nil, test.converted)
+			}
+
+			switch {
+			case test.wantTooLarge:
+				if !errors.Is(err, ErrImageTooLarge) {
+					t.Fatalf("decodeImage error = %v, want ErrImageTooLarge", err)
+				}
+				return
+			case test.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("decodeImage error = %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			release()
+			if got := img.Bounds().Size(); got != test.wantSize {
+				t.Errorf("decoded size = %v, want %v", got, test.wantSize)
+			}
+			if r, _, _, _ := img.At(10, 10).RGBA(); r>>8 != 255 {
+				t.Errorf("decoded pixel has red %d, want 255", r>>8)
+			}
+		})
+	}
+}
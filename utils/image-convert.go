@@ -6,6 +6,7 @@ import (
 	"image"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -16,57 +17,100 @@ import (
 	"github.com/kolesa-team/go-webp/decoder"
 	"github.com/kolesa-team/go-webp/encoder"
 	"github.com/kolesa-team/go-webp/webp"
-	"github.com/nfnt/resize"
 )
 
 // ResizeAndConvertToWebP resizes the input image to a specified width and converts it to WebP.
 func ResizeAndConvertToWebP(inputPath string, outputPath string, width uint) error {
+	img, release, err := decodeImage(inputPath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = processImage(img, outputPath, ImageVariant{Width: width, Mode: ResizeFit})
+	return err
+}
+
+// decodeImage decodes the image at inputPath after checking its declared dimensions against the
+// configured limits. The returned function releases the decode memory held by the image and must
+// be called once the image is no longer needed.
+func decodeImage(inputPath string) (image.Image, func(), error) {
 	// Open the source image file
 	file, err := os.Open(inputPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer file.Close()
 
-	// Read the first few bytes to check for WebP signature
-	header := make([]byte, 4)
-	if _, err := file.Read(header); err != nil {
-		return err
+	// Read the first few bytes to check for WebP and HEIF/AVIF signatures
+	header := make([]byte, 12)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	header = header[:n]
+
+	// HEIC/HEIF and AVIF are decoded by libheif, the standard library has no decoder for them
+	if isHEIFContainer(header) {
+		return decodeHEIF(inputPath)
+	}
+
+	// Reset file pointer after checking header before reading the image config
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Check if the file is a WebP file
-	if bytes.Equal(header, []byte("RIFF")) {
+	if bytes.HasPrefix(header, []byte("RIFF")) {
+		cfg, err := webp.DecodeConfig(file, &decoder.Options{})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		release, err := reserveDecodeMemory(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
 		// Seek back to the beginning of the file
 		_, err = file.Seek(0, 0)
 		if err != nil {
-			return err
+			release()
+			return nil, nil, err
 		}
 
 		// Decode the WebP image
 		img, err := webp.Decode(file, &decoder.Options{})
 		if err != nil {
-			return err
+			release()
+			return nil, nil, err
 		}
 
-		return processImage(img, outputPath, width)
+		return img, release, nil
 	}
 
-	// Reset file pointer after checking header before decoding the image
-	_, err = file.Seek(0, 0)
+	// Use image.DecodeConfig to get the image format and dimensions without relying on the file extension
+	cfg, format, err := image.DecodeConfig(file)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	if format != "png" && format != "jpeg" {
+		return nil, nil, errors.New("unsupported file type") // Unsupported file type
 	}
 
-	// Use image.DecodeConfig to get the image format without relying on the file extension
-	_, format, err := image.DecodeConfig(file)
+	// Reject oversized images before allocating their pixels
+	release, err := reserveDecodeMemory(cfg)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Reset file pointer after DecodeConfig since it reads part of the file
 	_, err = file.Seek(0, 0)
 	if err != nil {
-		return err
+		release()
+		return nil, nil, err
 	}
 
 	// Declare the image variable
@@ -76,60 +120,64 @@ func ResizeAndConvertToWebP(inputPath string, outputPath string, width uint) err
 	switch format {
 	case "png":
 		img, err = png.Decode(file)
-		if err != nil {
-			return err
-		}
 	case "jpeg":
 		img, err = jpeg.Decode(file)
-		if err != nil {
-			return err
-		}
-	default:
-		return errors.New("unsupported file type") // Unsupported file type
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
 	}
 
-	return processImage(img, outputPath, width)
+	return img, release, nil
 }
 
-// processImage resizes the image to the specified width while maintaining aspect ratio and encodes it to WebP format.
-func processImage(img image.Image, outputPath string, width uint) error {
-	// Get the original dimensions
-	bounds := img.Bounds()
-	originalWidth := bounds.Dx()
-	originalHeight := bounds.Dy()
-
-	// Calculate the new height while maintaining the aspect ratio
-	newHeight := uint(float64(originalHeight) * (float64(width) / float64(originalWidth)))
-
-	// Resize the image to the specified width while maintaining the aspect ratio
-	resizedImg := resize.Resize(width, newHeight, img, resize.Lanczos3)
+// processImage resizes the image into the variant's box using its resize mode and encodes it to WebP format.
+// It returns the dimensions of the written image.
+func processImage(img image.Image, outputPath string, variant ImageVariant) (image.Point, error) {
+	resizedImg := resizeForVariant(img, variant)
 
 	// Ensure the output directory exists, if not, create it
 	outputDir := filepath.Dir(outputPath)
 	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
 		err := os.MkdirAll(outputDir, os.ModePerm) // Create the directory with permissions
 		if err != nil {
-			return err
+			return image.Point{}, err
 		}
 	}
 
 	// Create the output WebP file
 	output, err := os.Create(outputPath)
 	if err != nil {
-		return err
+		return image.Point{}, err
 	}
 	defer output.Close()
 
 	// Set WebP encoding options (using lossy compression with quality = 75)
 	options, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, 75)
 	if err != nil {
-		return err
+		return image.Point{}, err
 	}
 
 	// Encode the resized image to WebP format
 	if err := webp.Encode(output, resizedImg, options); err != nil {
-		return err
+		return image.Point{}, err
+	}
+
+	return resizedImg.Bounds().Size(), nil
+}
+
+// WebPDimensions reads the width and height of a WebP file without decoding its pixels
+func WebPDimensions(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	cfg, err := webp.DecodeConfig(file, &decoder.Options{})
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return nil
+	return cfg.Width, cfg.Height, nil
 }
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrImageTooLarge is returned when an image exceeds the configured dimension or pixel limits
+var ErrImageTooLarge = errors.New("image too large")
+
+// decodeMemory bounds the memory held by images that are decoded at the same time
+var decodeMemory = semaphore.NewWeighted(config.ImageDecodeMemory)
+
+// checkImageConfig rejects images whose header declares dimensions above the configured limits,
+// before any pixel data is decoded
+func checkImageConfig(cfg image.Config) error {
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return fmt.Errorf("invalid image dimensions %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if cfg.Width > config.MaxImageWidth || cfg.Height > config.MaxImageHeight {
+		return fmt.Errorf("%w: %dx%d exceeds the maximum of %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height, config.MaxImageWidth, config.MaxImageHeight)
+	}
+
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > config.MaxImagePixels {
+		return fmt.Errorf("%w: %d pixels exceeds the maximum of %d", ErrImageTooLarge, pixels, config.MaxImagePixels)
+	}
+
+	return nil
+}
+
+// reserveDecodeMemory checks cfg against the limits and blocks until enough of the decode memory
+// budget is free to hold the image. The returned function releases the reservation.
+func reserveDecodeMemory(cfg image.Config) (func(), error) {
+	if err := checkImageConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	// An allowed image larger than the whole budget still gets decoded, just on its own
+	weight := min(decodedSize(cfg), config.ImageDecodeMemory)
+	if err := decodeMemory.Acquire(context.Background(), weight); err != nil {
+		return nil, err
+	}
+
+	return func() { decodeMemory.Release(weight) }, nil
+}
+
+// decodedSize approximates the memory an image takes once decoded
+func decodedSize(cfg image.Config) int64 {
+	return int64(cfg.Width) * int64(cfg.Height) * bytesPerPixel(cfg.ColorModel)
+}
+
+// bytesPerPixel approximates the decoded size of one pixel of the color model: 16 bits per channel
+// images (PNG, high bit depth HEIF) take 8 bytes, the others at most 4
+func bytesPerPixel(model color.Model) int64 {
+	switch model {
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	return 4
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestDecodedSize(t *testing.T) {
+	encode := func(encode func(*bytes.Buffer, image.Image) error, img image.Image) []byte {
+		var buf bytes.Buffer
+		if err := encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	encodePNG := func(buf *bytes.Buffer, img image.Image) error { return png.Encode(buf, img) }
+	encodeJPEG := func(buf *bytes.Buffer, img image.Image) error { return jpeg.Encode(buf, img, nil) }
+	rect := image.Rect(0, 0, 100, 50)
+
+	tests := []struct {
+		name string
+		file []byte
+		want int64
+	}{
+		{"8-bit PNG", encode(encodePNG, image.NewNRGBA(rect)), 100 * 50 * 4},
+		{"16-bit PNG", encode(encodePNG, image.NewNRGBA64(rect)), 100 * 50 * 8},
+		{"16-bit opaque PNG", encode(encodePNG, image.NewRGBA64(rect)), 100 * 50 * 8},
+		{"16-bit grayscale PNG", encode(encodePNG, image.NewGray16(rect)), 100 * 50 * 4},
+		{"JPEG", encode(encodeJPEG, image.NewRGBA(rect)), 100 * 50 * 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := decodedSize(cfg); got != test.want {
+				t.Errorf("decodedSize = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/nfnt/resize"
+)
+
+// ResizeMode selects how an image is fitted into a variant's box
+type ResizeMode string
+
+const (
+	// ResizeFit scales the image to fit inside the box, keeping its aspect ratio
+	ResizeFit ResizeMode = "fit"
+	// ResizeFill scales the image to cover the box and crops the overflow around the center
+	ResizeFill ResizeMode = "fill"
+	// ResizeSmart scales the image to cover the box and crops the window with the most detail
+	ResizeSmart ResizeMode = "smart"
+)
+
+// ImageVariant describes one WebP derivative generated for every image upload and video thumbnail
+type ImageVariant struct {
+	// Name is the file suffix, the variant is written to <id>-<Name>.webp
+	Name string
+	// Width and Height of the box in pixels, a Height of 0 keeps the aspect ratio at the given width
+	Width  uint
+	Height uint
+	Mode   ResizeMode
+}
+
+// ImageVariants is the image pipeline, parsed from config.ImageVariants
+var ImageVariants = mustParseImageVariants(config.ImageVariants)
+
+// ParseImageVariants parses a comma separated list of variants such as "500w,500x500:fill,400x500:smart".
+// "<w>w" fits the image to a width, "<w>x<h>" targets a box and defaults to the fit mode.
+func ParseImageVariants(spec string) ([]ImageVariant, error) {
+	var variants []ImageVariant
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		size, mode, _ := strings.Cut(entry, ":")
+		variant := ImageVariant{Name: size, Mode: ResizeMode(mode)}
+		if variant.Mode == "" {
+			variant.Mode = ResizeFit
+		}
+
+		switch variant.Mode {
+		case ResizeFit, ResizeFill, ResizeSmart:
+		default:
+			return nil, fmt.Errorf("unknown resize mode %q in variant %q", mode, entry)
+		}
+
+		if width, ok := strings.CutSuffix(size, "w"); ok {
+			w, err := strconv.ParseUint(width, 10, 32)
+			if err != nil || w == 0 {
+				return nil, fmt.Errorf("invalid width in variant %q", entry)
+			}
+			if variant.Mode != ResizeFit {
+				return nil, fmt.Errorf("variant %q needs a <w>x<h> box for the %s mode", entry, variant.Mode)
+			}
+			variant.Width = uint(w)
+		} else {
+			width, height, ok := strings.Cut(size, "x")
+			w, errW := strconv.ParseUint(width, 10, 32)
+			h, errH := strconv.ParseUint(height, 10, 32)
+			if !ok || errW != nil || errH != nil || w == 0 || h == 0 {
+				return nil, fmt.Errorf("invalid size in variant %q", entry)
+			}
+			variant.Width, variant.Height = uint(w), uint(h)
+		}
+
+		variants = append(variants, variant)
+	}
+
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no image variants configured")
+	}
+
+	return variants, nil
+}
+
+// mustParseImageVariants parses spec and falls back to a single 500px wide variant if it is invalid
+func mustParseImageVariants(spec string) []ImageVariant {
+	variants, err := ParseImageVariants(spec)
+	if err != nil {
+		slog.Warn("invalid image variants, using 500w", "spec", spec, "error", err)
+		return []ImageVariant{{Name: "500w", Width: 500, Mode: ResizeFit}}
+	}
+	return variants
+}
+
+// VariantPath returns the path of the WebP file for the given upload and variant inside outputDir
+func VariantPath(outputDir, id string, variant ImageVariant) string {
+	return filepath.Join(outputDir, id+"-"+variant.Name+".webp")
+}
+
+// VariantFile is a variant written by GenerateImageVariants
+type VariantFile struct {
+	Variant ImageVariant
+	Path    string
+	Width   int
+	Height  int
+	Size    int64
+}
+
+// GenerateImageVariants decodes the input image once, writes every variant to outputDir as <id>-<name>.webp
+// and returns the written files along with the placeholder computed from the same decoded image
+func GenerateImageVariants(inputPath, outputDir, id string, variants []ImageVariant) ([]VariantFile, Placeholder, error) {
+	img, release, err := decodeImage(inputPath)
+	if err != nil {
+		return nil, Placeholder{}, err
+	}
+	defer release()
+
+	files := make([]VariantFile, 0, len(variants))
+	for _, variant := range variants {
+		outputPath := VariantPath(outputDir, id, variant)
+		size, err := processImage(img, outputPath, variant)
+		if err != nil {
+			return nil, Placeholder{}, fmt.Errorf("variant %s: %w", variant.Name, err)
+		}
+
+		file := VariantFile{Variant: variant, Path: outputPath, Width: size.X, Height: size.Y}
+		if stat, err := os.Stat(outputPath); err == nil {
+			file.Size = stat.Size()
+		}
+		files = append(files, file)
+	}
+
+	placeholder, err := ComputePlaceholder(img)
+	return files, placeholder, err
+}
+
+// resizeForVariant scales and crops img into the variant's box according to its mode
+func resizeForVariant(img image.Image, variant ImageVariant) image.Image {
+	bounds := img.Bounds()
+	originalWidth := float64(bounds.Dx())
+	originalHeight := float64(bounds.Dy())
+
+	// Width only: keep the aspect ratio at the requested width
+	if variant.Height == 0 {
+		newHeight := uint(originalHeight * (float64(variant.Width) / originalWidth))
+		return resize.Resize(variant.Width, newHeight, img, resize.Lanczos3)
+	}
+
+	scaleX := float64(variant.Width) / originalWidth
+	scaleY := float64(variant.Height) / originalHeight
+
+	if variant.Mode == ResizeFit {
+		scale := min(scaleX, scaleY)
+		return resize.Resize(uint(originalWidth*scale), uint(originalHeight*scale), img, resize.Lanczos3)
+	}
+
+	// Fill and smart cover the box first, then crop the overflowing axis
+	scale := max(scaleX, scaleY)
+	newWidth := max(uint(originalWidth*scale+0.5), variant.Width)
+	newHeight := max(uint(originalHeight*scale+0.5), variant.Height)
+	resized := resize.Resize(newWidth, newHeight, img, resize.Lanczos3)
+
+	var offset image.Point
+	if variant.Mode == ResizeSmart {
+		offset = smartCropOffset(resized, int(variant.Width), int(variant.Height))
+	} else {
+		offset = image.Pt((int(newWidth)-int(variant.Width))/2, (int(newHeight)-int(variant.Height))/2)
+	}
+
+	return cropImage(resized, image.Rect(0, 0, int(variant.Width), int(variant.Height)).Add(resized.Bounds().Min.Add(offset)))
+}
+
+// cropImage copies the rect area of img into a new image whose bounds start at the origin
+func cropImage(img image.Image, rect image.Rectangle) image.Image {
+	cropped := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+	return cropped
+}
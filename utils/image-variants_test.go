@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestParseImageVariants(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []ImageVariant
+		wantErr bool
+	}{
+		{
+			spec: "500w",
+			want: []ImageVariant{{Name: "500w", Width: 500, Mode: ResizeFit}},
+		},
+		{
+			spec: "500w, 500x500:fill,400x500:smart ,200x100",
+			want: []ImageVariant{
+				{Name: "500w", Width: 500, Mode: ResizeFit},
+				{Name: "500x500", Width: 500, Height: 500, Mode: ResizeFill},
+				{Name: "400x500", Width: 400, Height: 500, Mode: ResizeSmart},
+				{Name: "200x100", Width: 200, Height: 100, Mode: ResizeFit},
+			},
+		},
+		{
+			spec: "500w:fit,,",
+			want: []ImageVariant{{Name: "500w", Width: 500, Mode: ResizeFit}},
+		},
+		{spec: "", wantErr: true},
+		{spec: " , ", wantErr: true},
+		{spec: "0w", wantErr: true},
+		{spec: "-5w", wantErr: true},
+		{spec: "500", wantErr: true},
+		{spec: "500x", wantErr: true},
+		{spec: "500x0", wantErr: true},
+		{spec: "axb", wantErr: true},
+		{spec: "500x500:stretch", wantErr: true},
+		{spec: "500w:fill", wantErr: true},
+		{spec: "500w:smart", wantErr: true},
+		{spec: "500w,500x500:crop", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			variants, err := ParseImageVariants(test.spec)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("ParseImageVariants = %+v, want an error", variants)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(variants, test.want) {
+				t.Errorf("ParseImageVariants = %+v, want %+v", variants, test.want)
+			}
+		})
+	}
+}
+
+// detailedImage returns a flat gray image whose right quarter is covered with random blocks
+func detailedImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y += 8 {
+		for x := 0; x < width; x += 8 {
+			c := color.RGBA{128, 128, 128, 255}
+			if x >= width*3/4 {
+				v := uint8(random.Intn(256))
+				c = color.RGBA{v, v, v, 255}
+			}
+			for dy := 0; dy < 8 && y+dy < height; dy++ {
+				for dx := 0; dx < 8 && x+dx < width; dx++ {
+					img.Set(x+dx, y+dy, c)
+				}
+			}
+		}
+	}
+	return img
+}
+
+func TestResizeForVariant(t *testing.T) {
+	wide, tall := detailedImage(400, 200), detailedImage(100, 400)
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		variant ImageVariant
+		want    image.Point
+	}{
+		{"width only", wide, ImageVariant{Width: 100, Mode: ResizeFit}, image.Pt(100, 50)},
+		{"fit wide into a square", wide, ImageVariant{Width: 100, Height: 100, Mode: ResizeFit}, image.Pt(100, 50)},
+		{"fit tall into a square", tall, ImageVariant{Width: 100, Height: 100, Mode: ResizeFit}, image.Pt(25, 100)},
+		{"fill wide", wide, ImageVariant{Width: 100, Height: 100, Mode: ResizeFill}, image.Pt(100, 100)},
+		{"fill tall", tall, ImageVariant{Width: 120, Height: 90, Mode: ResizeFill}, image.Pt(120, 90)},
+		{"smart wide", wide, ImageVariant{Width: 100, Height: 100, Mode: ResizeSmart}, image.Pt(100, 100)},
+		{"smart upscale", tall, ImageVariant{Width: 300, Height: 300, Mode: ResizeSmart}, image.Pt(300, 300)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resized := resizeForVariant(test.img, test.variant)
+			if got := resized.Bounds().Size(); got != test.want {
+				t.Errorf("size = %v, want %v", got, test.want)
+			}
+			if resized.Bounds().Min != (image.Point{}) {
+				t.Errorf("bounds start at %v, want the origin", resized.Bounds().Min)
+			}
+		})
+	}
+}
+
+func TestSmartCropKeepsTheDetail(t *testing.T) {
+	img := detailedImage(400, 200)
+	entropy := func(mode ResizeMode) float64 {
+		resized := resizeForVariant(img, ImageVariant{Width: 100, Height: 100, Mode: mode})
+		return windowEntropy(luminance(resized), 100, resized.Bounds())
+	}
+
+	// The center crop of the fill mode misses the detailed right quarter, the smart crop moves onto it
+	fill, smart := entropy(ResizeFill), entropy(ResizeSmart)
+	if smart <= fill {
+		t.Errorf("smart crop entropy %.2f, want more than the %.2f of the center crop", smart, fill)
+	}
+}
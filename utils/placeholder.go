@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// Placeholder holds what clients render while the real thumbnail is still loading
+type Placeholder struct {
+	// BlurHash is the https://blurha.sh encoding of the image
+	BlurHash string
+	// LQIP is a tiny, low quality JPEG of the image as a data URI
+	LQIP string
+	// DominantColor is the most common color of the image as #rrggbb
+	DominantColor string
+}
+
+// MetaData returns the placeholder as upload metadata entries
+func (p Placeholder) MetaData() map[string]string {
+	return map[string]string{
+		"blurhash":      p.BlurHash,
+		"lqip":          p.LQIP,
+		"dominantColor": p.DominantColor,
+	}
+}
+
+const (
+	// lqipWidth is the width of the LQIP JPEG, small enough to inline in an API response
+	lqipWidth = 16
+	// blurHashSampleWidth is the width the image is reduced to before computing the BlurHash
+	blurHashSampleWidth = 32
+	// blurHashComponentsX and blurHashComponentsY are the number of DCT components of the BlurHash
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+)
+
+// ComputePlaceholder derives the BlurHash, LQIP and dominant color of img
+func ComputePlaceholder(img image.Image) (Placeholder, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return Placeholder{}, fmt.Errorf("empty image")
+	}
+
+	sample := resize.Resize(blurHashSampleWidth, 0, img, resize.Bilinear)
+
+	lqip, err := encodeLQIP(img)
+	if err != nil {
+		return Placeholder{}, err
+	}
+
+	return Placeholder{
+		BlurHash:      encodeBlurHash(sample, blurHashComponentsX, blurHashComponentsY),
+		LQIP:          lqip,
+		DominantColor: dominantColor(sample),
+	}, nil
+}
+
+// encodeLQIP scales img down to lqipWidth pixels wide and returns it as a base64 JPEG data URI
+func encodeLQIP(img image.Image) (string, error) {
+	tiny := resize.Resize(lqipWidth, 0, img, resize.Bilinear)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, tiny, &jpeg.Options{Quality: 40}); err != nil {
+		return "", err
+	}
+
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// dominantColor buckets every pixel into 4 bits per channel and returns the average color of the fullest bucket
+func dominantColor(img image.Image) string {
+	type bucket struct{ r, g, b, count uint64 }
+	buckets := make(map[uint16]*bucket)
+
+	var best *bucket
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+			key := uint16(r>>4)<<8 | uint16(g>>4)<<4 | uint16(b>>4)
+
+			bk, ok := buckets[key]
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.r += uint64(r)
+			bk.g += uint64(g)
+			bk.b += uint64(b)
+			bk.count++
+
+			if best == nil || bk.count > best.count {
+				best = bk
+			}
+		}
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+// blurHashCharacters is the base83 alphabet used by BlurHash
+const blurHashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBlurHash implements the BlurHash encoding algorithm, see https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func encodeBlurHash(img image.Image, componentsX, componentsY int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Convert the image to linear RGB once instead of once per component
+	linear := make([][3]float64, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			linear = append(linear, [3]float64{sRGBToLinear(r >> 8), sRGBToLinear(g >> 8), sRGBToLinear(b >> 8)})
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := basisY * math.Cos(math.Pi*float64(i)*float64(x)/float64(width))
+					pixel := linear[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+
+	// Quantise the largest AC value so the remaining components can be stored relative to it
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMaximum := 0.0
+		for _, factor := range factors[1:] {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		quant := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quant(factor[0])*19*19+quant(factor[1])*19+quant(factor[2]), 2))
+	}
+
+	return hash.String()
+}
+
+// encodeBase83 encodes value with the BlurHash base83 alphabet, padded to length characters
+func encodeBase83(value, length int) string {
+	encoded := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		encoded[i-1] = blurHashCharacters[digit]
+	}
+	return string(encoded)
+}
+
+// sRGBToLinear converts an 8 bit sRGB channel to linear light in [0, 1]
+func sRGBToLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts linear light in [0, 1] back to an 8 bit sRGB channel
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow raises the magnitude of value to exp while keeping its sign
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testImage returns a width x height image colored by fn
+func testImage(width, height int, fn func(x, y int) color.RGBA) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, fn(x, y))
+		}
+	}
+	return img
+}
+
+func TestEncodeBlurHash(t *testing.T) {
+	// Computed with an implementation of the algorithm of https://github.com/woltapp/blurhash written
+	// apart from this one, which samples the basis functions at the pixel coordinates as the reference does
+	tests := []struct {
+		name                     string
+		img                      image.Image
+		componentsX, componentsY int
+		want                     string
+	}{
+		{
+			name:        "white",
+			img:         testImage(4, 4, func(x, y int) color.RGBA { return color.RGBA{255, 255, 255, 255} }),
+			componentsX: 4, componentsY: 3,
+			want: "L~TSUA~qfQ~q~q%MfQ%MfQfQfQfQ",
+		},
+		{
+			name:        "single component",
+			img:         testImage(3, 2, func(x, y int) color.RGBA { return color.RGBA{200, 30, 90, 255} }),
+			componentsX: 1, componentsY: 1,
+			want: "00M^#v",
+		},
+		{
+			name: "black and white halves",
+			img: testImage(8, 8, func(x, y int) color.RGBA {
+				if x < 4 {
+					return color.RGBA{0, 0, 0, 255}
+				}
+				return color.RGBA{255, 255, 255, 255}
+			}),
+			componentsX: 4, componentsY: 3,
+			want: "L~Lqe900D%?b%MRjWBt7fQfQfQfQ",
+		},
+		{
+			name:        "gradient",
+			img:         testImage(16, 16, func(x, y int) color.RGBA { return color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255} }),
+			componentsX: 4, componentsY: 4,
+			want: "UsGu,V2@wxozqSWEjte=gJfjfQfjs;WqjtfR",
+		},
+		{
+			name: "red over blue",
+			img: testImage(5, 6, func(x, y int) color.RGBA {
+				if y < 3 {
+					return color.RGBA{255, 0, 0, 255}
+				}
+				return color.RGBA{0, 0, 255, 255}
+			}),
+			componentsX: 3, componentsY: 5,
+			want: "c~LjfL,efQ|T$1fQ;usRfQfXfTfQfQfQfQ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := encodeBlurHash(test.img, test.componentsX, test.componentsY); got != test.want {
+				t.Errorf("encodeBlurHash = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"image"
+	"math"
+)
+
+// smartCropSteps is the number of candidate windows evaluated along the cropped axis
+const smartCropSteps = 24
+
+// smartCropOffset returns the top-left offset (relative to img.Bounds().Min) of the width x height
+// window with the highest luminance entropy. img must already cover the window on both axes, so
+// only the overflowing axis is searched.
+func smartCropOffset(img image.Image, width, height int) image.Point {
+	bounds := img.Bounds()
+	slackX := bounds.Dx() - width
+	slackY := bounds.Dy() - height
+	if slackX <= 0 && slackY <= 0 {
+		return image.Point{}
+	}
+
+	luma := luminance(img)
+	stride := bounds.Dx()
+
+	best := image.Point{}
+	bestEntropy := -1.0
+	for step := 0; step <= smartCropSteps; step++ {
+		candidate := image.Pt(slackX*step/smartCropSteps, slackY*step/smartCropSteps)
+		if e := windowEntropy(luma, stride, image.Rect(0, 0, width, height).Add(candidate)); e > bestEntropy {
+			best, bestEntropy = candidate, e
+		}
+	}
+
+	return best
+}
+
+// luminance converts img to 8 bit luma values laid out row by row
+func luminance(img image.Image) []uint8 {
+	bounds := img.Bounds()
+	luma := make([]uint8, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			// Rec. 601 weights on 16 bit channels, scaled back to 8 bits
+			luma = append(luma, uint8((19595*r+38470*g+7471*b+1<<15)>>24))
+		}
+	}
+	return luma
+}
+
+// windowEntropy computes the Shannon entropy of the luma histogram inside rect
+func windowEntropy(luma []uint8, stride int, rect image.Rectangle) float64 {
+	var histogram [256]int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		row := luma[y*stride : y*stride+stride]
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			histogram[row[x]]++
+		}
+	}
+
+	total := float64(rect.Dx() * rect.Dy())
+	entropy := 0.0
+	for _, count := range histogram {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
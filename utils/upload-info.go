@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/tus/tusd/v2/pkg/handler"
+)
+
+// ReadUploadInfo reads the tusd .info file stored next to an upload
+func ReadUploadInfo(infoPath string) (handler.FileInfo, error) {
+	var info handler.FileInfo
+
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return info, err
+	}
+
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// DirSize returns the total size of the regular files below dir, or of dir itself if it is a file
+func DirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// FreeSpace returns the bytes available to the service on the file system holding dir
+func FreeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,242 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+)
+
+// Webhook events
+const (
+	EventUploadCreated      = "upload.created"
+	EventUploadCompleted    = "upload.completed"
+	EventTranscodeSucceeded = "transcode.succeeded"
+	EventTranscodeFailed    = "transcode.failed"
+	EventMediaDeleted       = "media.deleted"
+)
+
+// Headers sent with every delivery. The signature is "t=<unix time>,v1=<hex HMAC-SHA256>", computed
+// with config.WebhookSecret over the timestamp, a dot and the raw body, so receivers can reject replays.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Payload is the JSON body of a webhook
+type Payload struct {
+	ID      string    `json:"id"`
+	Event   string    `json:"event"`
+	Created time.Time `json:"created"`
+	Upload  Upload    `json:"upload"`
+	// Job is set on transcode events
+	Job *db.Job `json:"job,omitempty"`
+}
+
+// Upload describes the upload an event is about. It leaves out the owner and the metadata sent by the
+// client, receivers look them up through the API if they need them.
+type Upload struct {
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Status  string    `json:"status"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	Deleted time.Time `json:"deleted,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// uploadPayload returns the description of an upload sent with its events
+func uploadPayload(upload db.Upload) Upload {
+	return Upload{
+		ID:      upload.ID,
+		Kind:    upload.Kind,
+		Status:  upload.Status,
+		Size:    upload.Size,
+		Created: upload.Created,
+		Deleted: upload.Deleted,
+		Error:   upload.Error,
+	}
+}
+
+// Validate rejects a configuration that would send webhooks unsigned: receivers could not tell them
+// from requests forged by anyone who knows their URL
+func Validate() error {
+	if len(config.WebhookURLs) > 0 && config.WebhookSecret == "" {
+		return errors.New("webhook URLs are configured without a secret")
+	}
+	return nil
+}
+
+// client sends the deliveries, each attempt is bounded by config.WebhookTimeout
+var client = &http.Client{Timeout: config.WebhookTimeout}
+
+// Publish sends an event about an upload to every configured webhook URL in the background.
+// Each delivery is recorded in the delivery log and retried until it succeeds or runs out of attempts.
+func Publish(event string, upload db.Upload, job *db.Job) {
+	if len(config.WebhookURLs) == 0 || (len(config.WebhookEvents) > 0 && !slices.Contains(config.WebhookEvents, event)) {
+		return
+	}
+
+	payload := Payload{ID: newID(), Event: event, Created: time.Now().UTC(), Upload: uploadPayload(upload), Job: job}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode webhook", "event", event, "upload_id", upload.ID, "error", err)
+		return
+	}
+
+	for i, url := range config.WebhookURLs {
+		delivery := db.Delivery{
+			ID:       fmt.Sprintf("%s-%d", payload.ID, i),
+			Event:    event,
+			UploadID: upload.ID,
+			URL:      url,
+			Payload:  body,
+			Status:   db.DeliveryPending,
+			Created:  payload.Created,
+		}
+		putDelivery(delivery)
+		go deliver(delivery)
+	}
+}
+
+// PublishUpload is Publish for callers that only have the id of the upload
+func PublishUpload(event, id string, job *db.Job) {
+	if len(config.WebhookURLs) == 0 {
+		return
+	}
+
+	upload, err := db.Default.GetUpload(id)
+	if err != nil {
+		slog.Error("failed to load upload for webhook", "event", event, "upload_id", id, "error", err)
+		return
+	}
+	Publish(event, upload, job)
+}
+
+// ResumeDeliveries retries the deliveries that were still pending when the previous run stopped
+func ResumeDeliveries() error {
+	pending, err := db.Default.Deliveries(db.DeliveryPending, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range pending {
+		if delay := time.Until(delivery.NextAttempt); delay > 0 {
+			time.AfterFunc(delay, func() { deliver(delivery) })
+		} else {
+			go deliver(delivery)
+		}
+	}
+
+	if len(pending) > 0 {
+		slog.Info("resumed webhook deliveries", "count", len(pending))
+	}
+	return nil
+}
+
+// deliver makes one attempt of a delivery and records its outcome, scheduling a retry on failure
+func deliver(delivery db.Delivery) {
+	logger := slog.With("delivery", delivery.ID, "event", delivery.Event, "upload_id", delivery.UploadID)
+
+	delivery.Attempts++
+	delivery.ResponseStatus, delivery.Error = 0, ""
+	status, err := post(delivery)
+	delivery.ResponseStatus = status
+
+	if err == nil {
+		delivery.Status, delivery.NextAttempt = db.DeliveryDelivered, time.Time{}
+		putDelivery(delivery)
+		logger.Info("webhook delivered", "status", status, "attempts", delivery.Attempts)
+		return
+	}
+
+	delivery.Error = err.Error()
+	if delivery.Attempts >= config.WebhookMaxAttempts {
+		delivery.Status, delivery.NextAttempt = db.DeliveryFailed, time.Time{}
+		putDelivery(delivery)
+		logger.Error("giving up on webhook", "attempts", delivery.Attempts, "error", err)
+		return
+	}
+
+	delay := retryDelay(delivery.Attempts)
+	delivery.NextAttempt = time.Now().UTC().Add(delay)
+	putDelivery(delivery)
+	logger.Warn("retrying webhook", "delay", delay.String(), "attempt", delivery.Attempts+1, "error", err)
+	time.AfterFunc(delay, func() { deliver(delivery) })
+}
+
+// retryDelay returns how long to wait before retrying a delivery that failed after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	delay := config.WebhookRetryBackoff
+	for i := 1; i < attempts && delay < config.WebhookRetryMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, config.WebhookRetryMaxBackoff)
+}
+
+// post sends the signed payload of a delivery and returns the response status. Any 2xx answer
+// counts as delivered.
+func post(delivery db.Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TusAce-Webhook")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderSignature, Sign(config.WebhookSecret, time.Now(), delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value of body sent at t
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// putDelivery records a delivery, logging failures
+func putDelivery(delivery db.Delivery) {
+	if err := db.Default.PutDelivery(delivery); err != nil {
+		slog.Error("failed to record webhook delivery", "delivery", delivery.ID, "error", err)
+	}
+}
+
+// newID returns 16 random bytes in hex
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,223 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LinuxSploit/TusAce/config"
+	"github.com/LinuxSploit/TusAce/db"
+)
+
+const testSecret = "test-secret"
+
+// request is a webhook request received by a receiver
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook receiver answering the statuses of statuses in turn, then 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []request
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, request{header: req.Header.Clone(), body: body})
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+// setup sends the webhooks to a receiver answering statuses, with a temporary database and retries
+// a few milliseconds apart
+func setup(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "media.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousDB := db.Default
+	db.Default = database
+	t.Cleanup(func() {
+		database.Close()
+		db.Default = previousDB
+	})
+
+	receiver := &receiver{statuses: statuses}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	previousURLs, previousSecret, previousEvents := config.WebhookURLs, config.WebhookSecret, config.WebhookEvents
+	previousAttempts, previousBackoff := config.WebhookMaxAttempts, config.WebhookRetryBackoff
+	config.WebhookURLs, config.WebhookSecret, config.WebhookEvents = []string{server.URL}, testSecret, nil
+	config.WebhookMaxAttempts, config.WebhookRetryBackoff = 3, 10*time.Millisecond
+	t.Cleanup(func() {
+		config.WebhookURLs, config.WebhookSecret, config.WebhookEvents = previousURLs, previousSecret, previousEvents
+		config.WebhookMaxAttempts, config.WebhookRetryBackoff = previousAttempts, previousBackoff
+	})
+
+	return receiver
+}
+
+// waitForDelivery waits until a delivery reaches status and returns it
+func waitForDelivery(t *testing.T, status string) db.Delivery {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		deliveries, err := db.Default.Deliveries(status, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) > 0 {
+			return deliveries[0]
+		}
+	}
+	t.Fatalf("no delivery became %s", status)
+	return db.Delivery{}
+}
+
+// verify checks the signature header of a webhook request the way a receiver would
+func verify(t *testing.T, req request) {
+	t.Helper()
+	timestamp, _, _ := strings.Cut(strings.TrimPrefix(req.header.Get(HeaderSignature), "t="), ",")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("signature %q has no timestamp", req.header.Get(HeaderSignature))
+	}
+	if want := Sign(testSecret, time.Unix(unix, 0), req.body); req.header.Get(HeaderSignature) != want {
+		t.Errorf("signature = %q, want %q", req.header.Get(HeaderSignature), want)
+	}
+}
+
+func testUpload() db.Upload {
+	return db.Upload{
+		ID:       "abc",
+		Kind:     db.KindVideo,
+		Owner:    "owner@example.com",
+		Status:   db.StatusReady,
+		Size:     1024,
+		Created:  time.Now().UTC(),
+		MetaData: map[string]string{"filename": "holiday.mp4", "email": "owner@example.com"},
+	}
+}
+
+func TestPublishRetriesUntilDelivered(t *testing.T) {
+	receiver := setup(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+
+	Publish(EventUploadCompleted, testUpload(), nil)
+	delivery := waitForDelivery(t, db.DeliveryDelivered)
+
+	if delivery.Attempts != 3 || delivery.ResponseStatus != http.StatusOK || delivery.Error != "" {
+		t.Errorf("delivery after %d attempts with status %d and error %q, want 3 attempts, 200 and no error",
+			delivery.Attempts, delivery.ResponseStatus, delivery.Error)
+	}
+	if delivery.Event != EventUploadCompleted || delivery.UploadID != "abc" {
+		t.Errorf("delivery of %s for %s, want %s for abc", delivery.Event, delivery.UploadID, EventUploadCompleted)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.requests) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(receiver.requests))
+	}
+	for i, req := range receiver.requests {
+		verify(t, req)
+		if req.header.Get(HeaderEvent) != EventUploadCompleted || req.header.Get(HeaderDelivery) != delivery.ID {
+			t.Errorf("request %d: event %q and delivery %q, want %q and %q",
+				i, req.header.Get(HeaderEvent), req.header.Get(HeaderDelivery), EventUploadCompleted, delivery.ID)
+		}
+		// Every attempt sends the payload recorded in the delivery log
+		if !bytes.Equal(req.body, delivery.Payload) {
+			t.Errorf("request %d: body %s, want %s", i, req.body, delivery.Payload)
+		}
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(delivery.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != EventUploadCompleted || payload.Upload.ID != "abc" || payload.Upload.Status != db.StatusReady {
+		t.Errorf("payload = %+v", payload)
+	}
+	for _, private := range []string{"owner@example.com", "holiday.mp4", "metaData", "owner"} {
+		if bytes.Contains(delivery.Payload, []byte(private)) {
+			t.Errorf("payload %s contains %q", delivery.Payload, private)
+		}
+	}
+}
+
+func TestPublishGivesUpAfterMaxAttempts(t *testing.T) {
+	receiver := setup(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+
+	Publish(EventTranscodeFailed, testUpload(), &db.Job{UploadID: "abc", Type: db.JobTranscode, Status: db.JobFailed})
+	delivery := waitForDelivery(t, db.DeliveryFailed)
+
+	if delivery.Attempts != 3 || delivery.ResponseStatus != http.StatusBadGateway || delivery.Error == "" {
+		t.Errorf("failed delivery after %d attempts with status %d and error %q, want 3 attempts, 502 and an error",
+			delivery.Attempts, delivery.ResponseStatus, delivery.Error)
+	}
+	if !delivery.NextAttempt.IsZero() {
+		t.Errorf("failed delivery has a next attempt at %s", delivery.NextAttempt)
+	}
+
+	// No attempt is made past the last one
+	time.Sleep(50 * time.Millisecond)
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.requests) != 3 {
+		t.Errorf("receiver got %d requests, want 3", len(receiver.requests))
+	}
+}
+
+func TestPublishSkipsFilteredEvents(t *testing.T) {
+	receiver := setup(t)
+	config.WebhookEvents = []string{EventMediaDeleted}
+
+	Publish(EventUploadCreated, testUpload(), nil)
+	deliveries, err := db.Default.Deliveries(db.DeliveryPending, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(deliveries) != 0 || len(receiver.requests) != 0 {
+		t.Errorf("filtered event recorded %d deliveries and sent %d requests", len(deliveries), len(receiver.requests))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		urls    []string
+		secret  string
+		wantErr bool
+	}{
+		{urls: nil, secret: "", wantErr: false},
+		{urls: []string{"https://example.com/hook"}, secret: testSecret, wantErr: false},
+		{urls: []string{"https://example.com/hook"}, secret: "", wantErr: true},
+	}
+
+	previousURLs, previousSecret := config.WebhookURLs, config.WebhookSecret
+	t.Cleanup(func() { config.WebhookURLs, config.WebhookSecret = previousURLs, previousSecret })
+	for _, test := range tests {
+		config.WebhookURLs, config.WebhookSecret = test.urls, test.secret
+		if err := Validate(); (err != nil) != test.wantErr {
+			t.Errorf("Validate with URLs %v and secret %q = %v, want an error: %v", test.urls, test.secret, err, test.wantErr)
+		}
+	}
+}